@@ -2,23 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
 	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/kafka"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/logging"
 	kafkaprom "github.com/kawamuray/prometheus-kafka-consumer-group-exporter/prometheus"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sink"
 	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/web"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 	"github.com/urfave/cli"
 )
 
 const consumerGroupCommandName = "kafka-consumer-groups.sh"
 const version = "0.0.6"
 
+var parserConfigFileFlag = cli.StringFlag{
+	Name:  "parser-config-file",
+	Usage: "Path to a YAML file defining extra parsers for the output of `kafka-consumer-groups.sh`, and the order parsers are tried in. See the README for its format.",
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "kafka_consumer_group_exporter"
@@ -30,11 +48,51 @@ func main() {
 			Usage: "Path to `kafka-consumer-groups.sh`.",
 			Value: consumerGroupCommandName,
 		},
+		parserConfigFileFlag,
+		cli.StringFlag{
+			Name:  "parse-mode",
+			Usage: "What to do with consumer group descriptions of which some lines cannot be parsed. `strict` rejects the group, `lenient` skips the lines and counts them in kafka_consumer_group_parse_skipped_lines.",
+			Value: "strict",
+		},
 		cli.StringFlag{
 			Name:  "listen",
 			Usage: "Interface and port to listen on.",
 			Value: ":7979",
 		},
+		cli.StringFlag{
+			Name:  "web-config-file",
+			Usage: "Path to a YAML file configuring TLS and basic authentication of the HTTP server. See the README for its format.",
+		},
+		cli.DurationFlag{
+			Name:  "web-read-timeout",
+			Usage: "The maximum time to read an HTTP request, including its body.",
+			Value: 30 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:  "web-write-timeout",
+			Usage: "The maximum time to answer an HTTP request. Scrapes answered without the cache wait for the Kafka command, so it should exceed `kafka-command-timeout`.",
+			Value: 6 * time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "web-idle-timeout",
+			Usage: "The maximum time to keep an idle keep-alive connection open.",
+			Value: 2 * time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "shutdown-timeout",
+			Usage: "The maximum time to shut down gracefully on SIGTERM or SIGINT. Keep it below the grace period of your process supervisor, 30s in Kubernetes by default.",
+			Value: 25 * time.Second,
+		},
+		cli.StringFlag{
+			Name:  "log-level",
+			Usage: "Only log entries of at least this level: debug, info, warn or error.",
+			Value: "info",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Usage: "Log format: " + strings.Join(logging.Formats, " or ") + ".",
+			Value: "logfmt",
+		},
 		cli.DurationFlag{
 			Name:  "kafka-command-timeout",
 			Usage: "The maximum time the Kafka command is allowed to take before we kill it. We've seen it block forever in production at times (most likely during rebalances).",
@@ -48,6 +106,206 @@ func main() {
 			// could be Value*256 MB.
 			Value: 4,
 		},
+		cli.BoolFlag{
+			Name:  "adaptive-concurrency",
+			Usage: "Lower the number of concurrent consumer group queries when they fail or are slower than `target-group-query-latency`, and raise it back up to `max-concurrent-group-queries` when they are not.",
+		},
+		cli.IntFlag{
+			Name:  "min-concurrent-group-queries",
+			Usage: "The lowest number of concurrent consumer group queries the adaptive concurrency limit may go down to.",
+			Value: 1,
+		},
+		cli.DurationFlag{
+			Name:  "target-group-query-latency",
+			Usage: "Consumer group queries slower than this lower the adaptive concurrency limit.",
+			Value: 30 * time.Second,
+		},
+		cli.Float64Flag{
+			Name:  "max-commands-per-second",
+			Usage: "The maximum number of Kafka commands started per second. Zero means unlimited.",
+		},
+		cli.IntFlag{
+			Name:  "retries",
+			Usage: "The number of times a Kafka command failing due to a transient error, such as a rebalance, is retried within `kafka-command-timeout`.",
+		},
+		cli.DurationFlag{
+			Name:  "retry-backoff",
+			Usage: "The maximum delay before the first retry. Doubles for every further retry. The actual delay is random up to this.",
+			Value: time.Second,
+		},
+		cli.DurationFlag{
+			Name:  "retry-max-backoff",
+			Usage: "The maximum delay before any retry.",
+			Value: 30 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:  "cache-ttl",
+			Usage: "How long results from Kafka are served from cache without being refreshed. Zero disables caching.",
+		},
+		cli.DurationFlag{
+			Name:  "cache-max-staleness",
+			Usage: "How old cached results may be when served while being refreshed in the background. Must be larger than `cache-ttl` to have any effect.",
+		},
+		cli.StringSliceFlag{
+			Name:  "group-schedule",
+			Usage: "Describe consumer groups fully matching REGEX at most every INTERVAL, and before groups with lower PRIORITY. On the format `INTERVAL:PRIORITY:REGEX`. Can be repeated; the first matching schedule is used. Other groups are described on every scrape with priority 0.",
+		},
+		cli.IntFlag{
+			Name:  "circuit-breaker-failures",
+			Usage: "Stop describing a consumer group after this many consecutive failures until a backoff has passed. Its last known state is exported meanwhile if `stale-series-grace-period` is set. Zero disables the circuit breaker.",
+		},
+		cli.DurationFlag{
+			Name:  "circuit-breaker-backoff",
+			Usage: "How long a consumer group is not described once its circuit breaker opens. Doubles for every further failure.",
+			Value: time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "circuit-breaker-max-backoff",
+			Usage: "The maximum time a consumer group is not described when its circuit breaker is open.",
+			Value: time.Hour,
+		},
+		cli.DurationFlag{
+			Name:  "stale-series-grace-period",
+			Usage: "Keep exporting the last known values of a consumer group for this long after it was last described successfully, also if it fails to be described or disappears. Zero disables it.",
+		},
+		cli.DurationFlag{
+			Name:  "lag-trend-window",
+			Usage: "Keep the samples of each partition from this window, and export lag velocity, consumption rate and estimated time to zero lag computed from them. Zero disables it.",
+		},
+		cli.StringFlag{
+			Name:  "metric-prefix",
+			Usage: "Prefix of the names of the exported consumer group metrics. `kafka_consumer_group` is recommended; the default is kept for backwards compatibility.",
+			Value: kafkaprom.LegacyMetricPrefix,
+		},
+		cli.BoolFlag{
+			Name:  "legacy-metric-names",
+			Usage: "Export all consumer group metrics with the `" + kafkaprom.LegacyMetricPrefix + "` prefix as well as `metric-prefix`, to ease migrating dashboards and alerts.",
+		},
+		cli.StringFlag{
+			Name:  "metric-timestamps",
+			Usage: "Which timestamps to attach to exported samples. `sample` attaches the time each sample is sent, `group` the time the describe of its consumer group completed, and `none` leaves it to Prometheus. Use `none` with remote write or federation.",
+			Value: "sample",
+		},
+		cli.BoolFlag{
+			Name:  "export-describe-completion-time",
+			Usage: "Export the time the describe of each consumer group completed as a separate gauge.",
+		},
+		cli.BoolFlag{
+			Name:  "openmetrics",
+			Usage: "Serve the OpenMetrics format if requested, and export partition ownership, consumer group state and describe durations with exemplars.",
+		},
+		cli.StringFlag{
+			Name:  "push-url",
+			Usage: "URL of a Pushgateway or remote write endpoint to periodically push all metrics to. Empty to disable.",
+		},
+		cli.StringFlag{
+			Name:  "push-mode",
+			Usage: "How to push to `push-url`. Either \"pushgateway\" or \"remote-write\".",
+			Value: "pushgateway",
+		},
+		cli.StringFlag{
+			Name:  "push-job",
+			Usage: "Job name to push to the Pushgateway under, or to set as `job` label on all series pushed with remote write.",
+			Value: "kafka_consumer_group_exporter",
+		},
+		cli.StringFlag{
+			Name:  "push-instance",
+			Usage: "Value of the `instance` label set on all series pushed with remote write. Defaults to the hostname.",
+		},
+		cli.StringSliceFlag{
+			Name:  "push-external-label",
+			Usage: "Label set on all series pushed with remote write, on the format `NAME=VALUE`, such as the cluster. Can be repeated.",
+		},
+		cli.DurationFlag{
+			Name:  "push-interval",
+			Usage: "How often to push metrics.",
+			Value: time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "push-timeout",
+			Usage: "Maximum time to gather and push metrics, including retries.",
+			Value: 30 * time.Second,
+		},
+		cli.IntFlag{
+			Name:  "push-retries",
+			Usage: "Maximum number of retries of a push failing with a transient error.",
+			Value: 3,
+		},
+		cli.DurationFlag{
+			Name:  "push-retry-backoff",
+			Usage: "Initial backoff before retrying a push. Doubled for every retry.",
+			Value: time.Second,
+		},
+		cli.DurationFlag{
+			Name:  "push-retry-max-backoff",
+			Usage: "Maximum backoff before retrying a push.",
+			Value: 10 * time.Second,
+		},
+		cli.IntFlag{
+			Name:  "push-max-samples-per-request",
+			Usage: "Maximum number of samples per remote write request. Pushgateway pushes are never split.",
+			Value: 2000,
+		},
+		cli.StringFlag{
+			Name:  "statsd-address",
+			Usage: "host:port of a StatsD server to send consumer group snapshots to. Empty to disable.",
+		},
+		cli.BoolFlag{
+			Name:  "dogstatsd",
+			Usage: "Send to `statsd-address` with DogStatsD tags instead of encoding them into metric names.",
+		},
+		cli.StringFlag{
+			Name:  "statsd-prefix",
+			Usage: "Prefix of all StatsD metric names.",
+			Value: "kafka_consumer_group",
+		},
+		cli.StringFlag{
+			Name:  "influxdb-url",
+			Usage: "InfluxDB write URL to send consumer group snapshots to, for example http://localhost:8086/write?db=kafka. Empty to disable.",
+		},
+		cli.StringFlag{
+			Name:  "influxdb-measurement",
+			Usage: "InfluxDB measurement to write consumer group snapshots as.",
+			Value: "kafka_consumer_group",
+		},
+		cli.DurationFlag{
+			Name:  "ready-list-window",
+			Usage: "The exporter is ready if it listed consumer groups successfully within this window. Groups are listed every half window unless scrapes list them.",
+			Value: 5 * time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "liveness-max-call-duration",
+			Usage: "The exporter is unhealthy if a call to Kafka has been running for longer than this, since it is likely deadlocked. Must exceed `kafka-command-timeout`.",
+			Value: 10 * time.Minute,
+		},
+		cli.BoolFlag{
+			Name:  "debug-describe",
+			Usage: "Serve /debug/describe?group=GROUP, which describes a consumer group right away and returns the raw command output.",
+		},
+		cli.StringFlag{
+			Name:  "debug-username",
+			Usage: "Username required to access /debug/describe. Empty to not require authentication.",
+		},
+		cli.StringFlag{
+			Name:   "debug-password",
+			Usage:  "Password required to access /debug/describe. Must not be empty if `debug-username` is set.",
+			EnvVar: "DEBUG_PASSWORD",
+		},
+		cli.DurationFlag{
+			Name:  "sink-interval",
+			Usage: "How often to send consumer group snapshots to StatsD and InfluxDB.",
+			Value: time.Minute,
+		},
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:      "test-parser",
+			Usage:     "Check which parser parses a sample output of `kafka-consumer-groups.sh --describe`, and how.",
+			ArgsUsage: "SAMPLE_FILE",
+			Flags:     []cli.Flag{parserConfigFileFlag},
+			Action:    testParser,
+		},
 	}
 
 	app.Action = func(c *cli.Context) {
@@ -55,33 +313,256 @@ func main() {
 			log.Fatal("Bootstrap server(s) missing.")
 		}
 		bootstrapServers := c.Args().Get(0)
+		if err := logging.Configure(c.String("log-format"), c.String("log-level"), log.Fields{"cluster": bootstrapServers}); err != nil {
+			log.Fatal("Invalid logging configuration: ", err)
+		}
 
 		consumerGroupCommandPath := c.String("consumer-group-command-path")
-		if data, err := os.Stat(consumerGroupCommandPath); os.IsNotExist(err) {
-			log.Fatal("`consumer-group-command-path` does not exist. File: ", consumerGroupCommandPath)
-		} else if err != nil {
-			log.Fatal("Unable to to stat() `consumer-group-command-path`. Error: ", err)
-		} else if perm := data.Mode().Perm(); perm&0111 == 0 {
-			log.Fatal("`consumer-group-command-path` does not have executable bit set. File: ", consumerGroupCommandPath)
+		if err := kafka.CheckCommandPath(consumerGroupCommandPath); err != nil {
+			log.Fatal("Invalid `consumer-group-command-path`: ", err)
 		}
 
+		// ctx is cancelled on shutdown, aborting all calls to Kafka.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		parser, err := describeGroupParser(c.String("parser-config-file"))
+		if err != nil {
+			log.Fatal("Invalid `parser-config-file`: ", err)
+		}
+		if parser.Mode, err = kafka.ParseParseMode(c.String("parse-mode")); err != nil {
+			log.Fatal("Invalid `parse-mode`: ", err)
+		}
+		prometheus.DefaultRegisterer.MustRegister(parser)
 		kafkaClient := kafka.ConsumerGroupsCommandClient{
-			Parser:                   kafka.DefaultDescribeGroupParser(),
+			Parser:                   parser,
 			BootstrapServers:         bootstrapServers,
 			ConsumerGroupCommandPath: consumerGroupCommandPath,
 		}
+		var commandClient exporter.ConsumerGroupInfoClient = &kafkaClient
+		var limitingClient *sync.LimitingConsumerGroupInfoClient
+		var limiter *sync.AdaptiveLimiter
+		var rateLimiter *sync.RateLimiter
+		if c.Bool("adaptive-concurrency") {
+			limiter = sync.NewAdaptiveLimiter(
+				c.Int("min-concurrent-group-queries"),
+				c.Int("max-concurrent-group-queries"),
+				c.Duration("target-group-query-latency"),
+			)
+		}
+		if perSecond := c.Float64("max-commands-per-second"); perSecond > 0 {
+			rateLimiter = sync.NewRateLimiter(perSecond, 1)
+		}
+		if limiter != nil || rateLimiter != nil {
+			limitingClient = sync.NewLimitingConsumerGroupInfoClient(&kafkaClient, limiter, rateLimiter)
+			prometheus.DefaultRegisterer.MustRegister(limitingClient)
+			commandClient = limitingClient
+		}
+		if retries := c.Int("retries"); retries > 0 {
+			retryingClient := sync.NewRetryingConsumerGroupInfoClient(
+				commandClient,
+				retries,
+				c.Duration("retry-backoff"),
+				c.Duration("retry-max-backoff"),
+			)
+			prometheus.DefaultRegisterer.MustRegister(retryingClient)
+			commandClient = retryingClient
+		}
 		fanInClient := sync.FanInConsumerGroupInfoClient{
-			Delegate: &kafkaClient,
+			Delegate: commandClient,
+		}
+		var client exporter.ConsumerGroupInfoClient = &fanInClient
+		if ttl := c.Duration("cache-ttl"); ttl > 0 {
+			cachingClient := sync.NewCachingConsumerGroupInfoClient(
+				ctx,
+				client,
+				ttl,
+				c.Duration("cache-max-staleness"),
+				c.Duration("kafka-command-timeout"),
+			)
+			prometheus.DefaultRegisterer.MustRegister(cachingClient)
+			client = cachingClient
+		}
+		if specs := c.StringSlice("group-schedule"); len(specs) > 0 {
+			var schedules []sync.GroupSchedule
+			for _, spec := range specs {
+				schedule, err := sync.ParseGroupSchedule(spec)
+				if err != nil {
+					log.Fatal("Invalid `group-schedule`: ", err)
+				}
+				schedules = append(schedules, schedule)
+			}
+			schedulingClient := sync.NewSchedulingConsumerGroupInfoClient(client, schedules)
+			prometheus.DefaultRegisterer.MustRegister(schedulingClient)
+			client = schedulingClient
+		}
+		if threshold := c.Int("circuit-breaker-failures"); threshold > 0 {
+			breakingClient := sync.NewCircuitBreakingConsumerGroupInfoClient(
+				client,
+				threshold,
+				c.Duration("circuit-breaker-backoff"),
+				c.Duration("circuit-breaker-max-backoff"),
+			)
+			prometheus.DefaultRegisterer.MustRegister(breakingClient)
+			client = breakingClient
+		}
+		timestampMode, err := kafkaprom.ParseTimestampMode(c.String("metric-timestamps"))
+		if err != nil {
+			log.Fatal("Invalid `metric-timestamps`: ", err)
+		}
+		collectorOpts := []kafkaprom.CollectorOption{
+			kafkaprom.WithTimestampMode(timestampMode),
+			kafkaprom.WithMetricPrefix(c.String("metric-prefix")),
+			kafkaprom.WithStaleSeriesGracePeriod(c.Duration("stale-series-grace-period")),
+			kafkaprom.WithLagTrendWindow(c.Duration("lag-trend-window")),
 		}
-		collector := kafkaprom.NewPartitionInfoCollector(
-			context.Background(),
-			&fanInClient,
+		if c.Bool("legacy-metric-names") {
+			collectorOpts = append(collectorOpts, kafkaprom.WithLegacyMetricNames())
+		}
+		if c.Bool("export-describe-completion-time") {
+			collectorOpts = append(collectorOpts, kafkaprom.WithDescribeCompletionGauge())
+		}
+		if c.Bool("openmetrics") {
+			collectorOpts = append(collectorOpts, kafkaprom.WithOpenMetricsFamilies())
+		}
+		recordingClient := sync.NewRecordingConsumerGroupInfoClient(client)
+		collector, err := kafkaprom.NewPartitionInfoCollector(
+			ctx,
+			recordingClient,
 			c.Duration("kafka-command-timeout"),
 			c.Int("max-concurrent-group-queries"),
+			collectorOpts...,
 		)
+		if err != nil {
+			log.Fatal("Invalid collector configuration: ", err)
+		}
 		prometheus.DefaultRegisterer.MustRegister(collector)
 
-		log.Fatal(http.ListenAndServe(c.String("listen"), promhttp.Handler()))
+		if url := c.String("push-url"); url != "" {
+			var target kafkaprom.PushTarget
+			maxSamplesPerRequest := 0
+			switch mode := c.String("push-mode"); mode {
+			case "pushgateway":
+				target = kafkaprom.NewPushgatewayTarget(url, c.String("push-job"), http.DefaultClient)
+			case "remote-write":
+				labels, err := externalLabels(c.String("push-instance"), c.StringSlice("push-external-label"))
+				if err != nil {
+					log.Fatal("Invalid `push-external-label`: ", err)
+				}
+				target = kafkaprom.NewRemoteWriteTarget(url, c.String("push-job"), labels, http.DefaultClient)
+				maxSamplesPerRequest = c.Int("push-max-samples-per-request")
+			default:
+				log.Fatal("Invalid `push-mode`: ", mode)
+			}
+			pusher := kafkaprom.NewPusher(
+				prometheus.DefaultGatherer,
+				target,
+				c.Duration("push-interval"),
+				c.Duration("push-timeout"),
+				c.Int("push-retries"),
+				c.Duration("push-retry-backoff"),
+				c.Duration("push-retry-max-backoff"),
+				maxSamplesPerRequest,
+			)
+			prometheus.DefaultRegisterer.MustRegister(pusher)
+			go pusher.Run(ctx)
+		}
+
+		poller := sink.NewPoller(
+			recordingClient,
+			collector,
+			c.Duration("sink-interval"),
+			c.Duration("kafka-command-timeout"),
+		)
+		if address := c.String("statsd-address"); address != "" {
+			statsdSink, err := sink.NewStatsdSink(address, c.String("statsd-prefix"), c.Bool("dogstatsd"))
+			if err != nil {
+				log.Fatal("Invalid `statsd-address`: ", err)
+			}
+			poller.AddSink("statsd", statsdSink)
+		}
+		if url := c.String("influxdb-url"); url != "" {
+			poller.AddSink("influxdb", sink.NewInfluxSink(url, c.String("influxdb-measurement"), http.DefaultClient))
+		}
+		if c.String("statsd-address") != "" || c.String("influxdb-url") != "" {
+			prometheus.DefaultRegisterer.MustRegister(poller)
+			go poller.Run(ctx)
+		}
+
+		var metricsHandler http.Handler = promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics: c.Bool("openmetrics"),
+		})
+		if c.Bool("openmetrics") {
+			metricsHandler = kafkaprom.NewOpenMetricsHandler(prometheus.DefaultGatherer, collector.OpenMetricsTypes(), metricsHandler)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, metricsHandler))
+		mux.Handle("/", web.NewStatusPage(bootstrapServers, recordingClient))
+		mux.Handle(web.APIPrefix, web.NewAPI(recordingClient))
+		ready := web.NewProbe(c.Duration("kafka-command-timeout"))
+		ready.AddCheck("command", func(context.Context) error {
+			return kafka.CheckCommandPath(consumerGroupCommandPath)
+		})
+		ready.AddCheck("list", web.ListedWithinCheck(recordingClient, c.Duration("ready-list-window")))
+		go web.KeepListed(ctx, recordingClient, c.Duration("ready-list-window")/2, c.Duration("kafka-command-timeout"))
+		mux.Handle(web.ReadyPath, ready)
+		healthy := web.NewProbe(time.Second)
+		healthy.AddCheck("kafka", web.InFlightCheck(fanInClient.OldestCall, c.Duration("liveness-max-call-duration")))
+		mux.Handle(web.HealthyPath, healthy)
+		if c.Bool("debug-describe") {
+			var describer web.DebugDescriber = &kafkaClient
+			if limitingClient != nil {
+				describer = web.NewLimitedDebugDescriber(describer, limitingClient)
+			}
+			debugHandler, err := web.NewDebugHandler(
+				describer,
+				c.Duration("kafka-command-timeout"),
+				c.Int("max-concurrent-group-queries"),
+				c.String("debug-username"),
+				c.String("debug-password"),
+			)
+			if err != nil {
+				log.Fatal("Invalid debug credentials: ", err)
+			}
+			mux.Handle(web.DebugDescribePath, debugHandler)
+		}
+
+		var webConfig *web.Config
+		if path := c.String("web-config-file"); path != "" {
+			if webConfig, err = web.LoadConfig(path); err != nil {
+				log.Fatal("Invalid `web-config-file`: ", err)
+			}
+		}
+		server, err := web.NewServer(
+			c.String("listen"),
+			mux,
+			webConfig,
+			c.Duration("web-read-timeout"),
+			c.Duration("web-write-timeout"),
+			c.Duration("web-idle-timeout"),
+		)
+		if err != nil {
+			log.Fatal("Could not configure the HTTP server: ", err)
+		}
+		// Requests are aborted on shutdown too, and the context is only
+		// cancelled once no new requests are accepted.
+		server.BaseContext = func(net.Listener) context.Context { return ctx }
+		server.RegisterOnShutdown(cancel)
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		served := make(chan error, 1)
+		go func() { served <- web.Serve(server) }()
+		select {
+		case err := <-served:
+			log.Fatal(err)
+		case sig := <-signals:
+			log.WithField("signal", sig).Info("Shutting down")
+		}
+		if err := shutdown(server, &fanInClient, c.Duration("shutdown-timeout")); err != nil {
+			log.Fatal("Could not shut down gracefully: ", err)
+		}
+		log.Info("Shut down")
 	}
 
 	err := app.Run(os.Args)
@@ -89,3 +570,104 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// shutdown stops server from accepting requests, which cancels the context of
+// all calls to Kafka, and waits for the requests to complete and for the Kafka
+// commands in flight to be killed, for at most timeout.
+func shutdown(server *http.Server, fanInClient *sync.FanInConsumerGroupInfoClient, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		fanInClient.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return errors.New("commands to Kafka are still running")
+	}
+}
+
+// describeGroupParser returns the parser configured by the parser config file
+// at path, or the default parser if path is empty.
+func describeGroupParser(path string) (*kafka.DelegatingParser, error) {
+	if path == "" {
+		return kafka.DefaultDescribeGroupParser(), nil
+	}
+	config, err := kafka.LoadParsersConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.DelegatingParser()
+}
+
+// externalLabels returns the labels to set on all series pushed with remote
+// write: instance, or the hostname if it is empty, and the NAME=VALUE pairs of
+// specs.
+func externalLabels(instance string, specs []string) (map[string]string, error) {
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("could not get the hostname as instance: %s", err)
+		}
+		instance = hostname
+	}
+	labels := map[string]string{"instance": instance}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || !model.LabelName(parts[0]).IsValid() {
+			return nil, fmt.Errorf("%q is not on the format NAME=VALUE", spec)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
+// testParser parses a sample output with every configured parser, and prints
+// what each made of it.
+func testParser(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Sample output file missing.", 2)
+	}
+	path := c.String("parser-config-file")
+	if path == "" {
+		path = c.GlobalString("parser-config-file")
+	}
+	parser, err := describeGroupParser(path)
+	if err != nil {
+		return cli.NewExitError("Invalid `parser-config-file`: "+err.Error(), 2)
+	}
+	sample, err := ioutil.ReadFile(c.Args().Get(0))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+
+	matched := false
+	for _, result := range parser.ParseEach(kafka.CommandOutput{Stdout: string(sample)}) {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("%s: %s\n", result.Parser, result.Err)
+		case matched:
+			fmt.Printf("%s: parsed %d partitions, but is tried after the one used\n", result.Parser, len(result.Partitions))
+		default:
+			matched = true
+			fmt.Printf("%s: parsed %d partitions, and is used\n", result.Parser, len(result.Partitions))
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "\tTOPIC\tPARTITION\tCURRENT-OFFSET\tLAG\tCLIENT-ID\tCONSUMER-ADDRESS")
+			for _, part := range result.Partitions {
+				fmt.Fprintf(w, "\t%s\t%s\t%d\t%d\t%s\t%s\n", part.Topic, part.PartitionID, part.CurrentOffset, part.Lag, part.ClientID, part.ConsumerAddress)
+			}
+			w.Flush()
+		}
+	}
+	if !matched {
+		return cli.NewExitError("No parser could parse the sample.", 1)
+	}
+	return nil
+}
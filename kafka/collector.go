@@ -3,11 +3,25 @@ package kafka
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"strings"
+	"time"
 
 	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/logging"
+	log "github.com/sirupsen/logrus"
 )
 
+// parseWarningInterval is how often parse failures of a consumer group are
+// logged at most. They tend to repeat on every scrape until Kafka or the
+// exporter is upgraded.
+const parseWarningInterval = 5 * time.Minute
+
+var parseWarnings = logging.NewLimiter(parseWarningInterval)
+
 // DescribeGroupParser parses the output from `kafka-consumer-group.sh --describe`.
 type DescribeGroupParser interface {
 	// Parses the output from `kafka-consumer-group.sh --describe`
@@ -22,10 +36,29 @@ type ConsumerGroupsCommandClient struct {
 	ConsumerGroupCommandPath string
 }
 
+// CheckCommandPath returns an error unless path is an executable file, such as
+// `kafka-consumer-groups.sh`.
+func CheckCommandPath(path string) error {
+	data, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s does not exist", path)
+	} else if err != nil {
+		return fmt.Errorf("unable to stat() %s: %s", path, err)
+	} else if perm := data.Mode().Perm(); perm&0111 == 0 {
+		return fmt.Errorf("%s does not have executable bit set", path)
+	}
+	return nil
+}
+
 // CommandOutput is the output from a DescribeGroupParser.
 type CommandOutput struct {
 	Stdout string
 	Stderr string
+	// Cluster is the bootstrap servers of the cluster the command ran
+	// against.
+	Cluster string
+	// Group is the consumer group described, if any.
+	Group string
 }
 
 func (col *ConsumerGroupsCommandClient) execConsumerGroupCommand(ctx context.Context, args ...string) (output CommandOutput, err error) {
@@ -36,6 +69,7 @@ func (col *ConsumerGroupsCommandClient) execConsumerGroupCommand(ctx context.Con
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
+	startInProcessGroup(cmd)
 	if err = cmd.Start(); err != nil {
 		return
 	}
@@ -44,7 +78,7 @@ func (col *ConsumerGroupsCommandClient) execConsumerGroupCommand(ctx context.Con
 	go func() {
 		select {
 		case <-ctx.Done():
-			cmd.Process.Kill()
+			killProcessGroup(cmd)
 		case <-quitChan:
 			return
 		}
@@ -57,12 +91,27 @@ func (col *ConsumerGroupsCommandClient) execConsumerGroupCommand(ctx context.Con
 
 	output.Stdout = string(stdout.Bytes())
 	output.Stderr = string(stderr.Bytes())
+	output.Cluster = col.BootstrapServers
 	return
 }
 
+// retryableError returns a *exporter.RetryableError if output shows that the
+// command failed for a transient reason, and nil otherwise.
+func retryableError(output CommandOutput) error {
+	if !isRetryableOutput(output) {
+		return nil
+	}
+	return &exporter.RetryableError{
+		Err: fmt.Errorf("transient error from Kafka: %s", strings.TrimSpace(output.Stderr+"\n"+output.Stdout)),
+	}
+}
+
 // Groups returns a list of the Kafka consumer groups.
 func (col *ConsumerGroupsCommandClient) Groups(ctx context.Context) ([]string, error) {
 	output, err := col.execConsumerGroupCommand(ctx, "--list")
+	if retryErr := retryableError(output); retryErr != nil {
+		return nil, retryErr
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -73,8 +122,76 @@ func (col *ConsumerGroupsCommandClient) Groups(ctx context.Context) ([]string, e
 // consumer group.
 func (col *ConsumerGroupsCommandClient) DescribeGroup(ctx context.Context, group string) ([]exporter.PartitionInfo, error) {
 	output, err := col.execConsumerGroupCommand(ctx, "--describe", "--group", group)
+	fetchedAt := time.Now()
+	output.Group = group
+	if retryErr := retryableError(output); retryErr != nil {
+		return nil, retryErr
+	}
 	if err != nil {
 		return nil, err
 	}
-	return col.Parser.Parse(output)
+	partitions, err := col.Parser.Parse(output)
+	if err != nil {
+		warnParseError(col.BootstrapServers, group, err, "Could not parse group description")
+	}
+	for i := range partitions {
+		partitions[i].FetchedAt = fetchedAt
+	}
+	return partitions, err
+}
+
+// warnParseError logs msg about the description of group not being parsed
+// because of err, along with the partition that failed if known.
+func warnParseError(cluster, group string, err error, msg string) {
+	ok, suppressed := parseWarnings.Allow(cluster + "\x00" + group)
+	if !ok {
+		return
+	}
+	entry := log.WithFields(log.Fields{"cluster": cluster, "group": group})
+	var lineErr *lineError
+	if errors.As(err, &lineErr) && lineErr.Topic != "" {
+		entry = entry.WithFields(log.Fields{"topic": lineErr.Topic, "partition": lineErr.Partition})
+	}
+	if suppressed > 0 {
+		entry = entry.WithField("suppressed", suppressed)
+	}
+	entry.WithError(err).Warn(msg)
+}
+
+// DescribeGroupDebugInfo is everything seen while describing a consumer group.
+type DescribeGroupDebugInfo struct {
+	Output CommandOutput
+	// Parser is the parser that parsed the output. Empty if none did.
+	Parser     string
+	Partitions []exporter.PartitionInfo
+	Err        error
+}
+
+// DebugDescribeGroup describes a consumer group like DescribeGroup, but also
+// returns the raw command output and the parser that parsed it.
+func (col *ConsumerGroupsCommandClient) DebugDescribeGroup(ctx context.Context, group string) DescribeGroupDebugInfo {
+	var info DescribeGroupDebugInfo
+	info.Output, info.Err = col.execConsumerGroupCommand(ctx, "--describe", "--group", group)
+	info.Output.Group = group
+	if retryErr := retryableError(info.Output); retryErr != nil {
+		info.Err = retryErr
+		return info
+	}
+	if info.Err != nil {
+		return info
+	}
+
+	if delegating, ok := col.Parser.(*DelegatingParser); ok {
+		var parser DescribeGroupParser
+		info.Partitions, parser, info.Err = delegating.ParseMatching(info.Output)
+		if parser != nil {
+			info.Parser = fmt.Sprint(parser)
+		}
+		return info
+	}
+	info.Partitions, info.Err = col.Parser.Parse(info.Output)
+	if info.Err == nil {
+		info.Parser = fmt.Sprint(col.Parser)
+	}
+	return info
 }
@@ -2,11 +2,17 @@ package kafka
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
 	. "testing"
 	"time"
 
 	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/logging"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 )
 
 func TestKafkaPartitionExecution(t *T) {
@@ -101,3 +107,120 @@ func TestKafkaDownFails(t *T) {
 		t.Error("Expected an error when not being able to connect to Kafka.")
 	}
 }
+
+// fakeCommand writes a script printing stdout in place of
+// `kafka-consumer-groups.sh` and returns its path.
+func fakeCommand(t *T, stdout string) string {
+	return fakeScript(t, "cat <<'EOF'\n"+stdout+"\nEOF\n")
+}
+
+// fakeScript writes a shell script running script in place of
+// `kafka-consumer-groups.sh` and returns its path.
+func fakeScript(t *T, script string) string {
+	dir, err := ioutil.TempDir("", "kafka-consumer-groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "kafka-consumer-groups.sh")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDebugDescribeGroup(t *T) {
+	consumer := ConsumerGroupsCommandClient{
+		DefaultDescribeGroupParser(),
+		"localhost:9092",
+		fakeCommand(t, `TOPIC                          PARTITION  CURRENT-OFFSET  LOG-END-OFFSET  LAG        CONSUMER-ID                                       HOST                           CLIENT-ID
+topic1           0          3545            3547            2          consumer-1-583b2298-f285-4696-ba0e-576109284592   /10.21.95.43                   consumer-1`),
+	}
+
+	info := consumer.DebugDescribeGroup(context.Background(), "default")
+	if info.Err != nil {
+		t.Fatal("Unexpected error:", info.Err)
+	}
+	if len(info.Partitions) != 1 || info.Partitions[0].Lag != 2 {
+		t.Error("Unexpected partitions:", info.Partitions)
+	}
+	if info.Parser != kafka0_10_2_1DescribeGroupParser.String() {
+		t.Error("Unexpected parser:", info.Parser)
+	}
+	if info.Output.Stdout == "" {
+		t.Error("Expected the raw output.")
+	}
+
+	consumer.ConsumerGroupCommandPath = fakeCommand(t, "garbage")
+	info = consumer.DebugDescribeGroup(context.Background(), "default")
+	if info.Err == nil || info.Parser != "" || info.Output.Stdout != "garbage\n" {
+		t.Error("Expected the raw output of an unparseable describe. Got:", info)
+	}
+}
+
+func TestCheckCommandPath(t *T) {
+	path := fakeCommand(t, "")
+	if err := CheckCommandPath(path); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCommandPath(path); err == nil {
+		t.Error("Expected a non-executable file to be rejected.")
+	}
+	if err := CheckCommandPath(path + ".missing"); err == nil {
+		t.Error("Expected a missing file to be rejected.")
+	}
+}
+
+func TestCancelKillsChildProcesses(t *T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Processes started by the command are not killed on Windows.")
+	}
+	// The sleep is a child of the script, as the JVM is when the script does
+	// not exec it, and holds on to its output.
+	consumer := ConsumerGroupsCommandClient{
+		DefaultDescribeGroupParser(),
+		"localhost:9092",
+		fakeScript(t, "sleep 60\n"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := consumer.DescribeGroup(ctx, "default"); err == nil {
+		t.Error("Expected an error from a killed command.")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Error("Expected the command to be killed right away. Took:", elapsed)
+	}
+}
+
+func TestDescribeGroupLogsParseErrors(t *T) {
+	hook := logtest.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	// Forget the entries logged by earlier runs with -count.
+	parseWarnings = logging.NewLimiter(parseWarningInterval)
+
+	consumer := ConsumerGroupsCommandClient{
+		DefaultDescribeGroupParser(),
+		"localhost:9092",
+		fakeCommand(t, `TOPIC                          PARTITION  CURRENT-OFFSET  LOG-END-OFFSET  LAG        CONSUMER-ID                                       HOST                           CLIENT-ID
+topic1           3          -            3547            2          consumer-1-583b2298-f285-4696-ba0e-576109284592   /10.21.95.43                   consumer-1`),
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := consumer.DescribeGroup(context.Background(), "logged"); err == nil {
+			t.Fatal("Expected a parse error.")
+		}
+	}
+	if len(hook.Entries) != 1 {
+		t.Fatal("Expected repeated parse errors to be logged once. Got:", len(hook.Entries))
+	}
+	entry := hook.LastEntry()
+	if entry.Level != log.WarnLevel || entry.Data["group"] != "logged" || entry.Data["cluster"] != "localhost:9092" ||
+		entry.Data["topic"] != "topic1" || entry.Data["partition"] != "3" {
+		t.Error("Unexpected entry:", entry.Level, entry.Data)
+	}
+}
@@ -6,15 +6,68 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
-	"github.com/prometheus/common/log"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
 )
 
 const missingColumnValue = "-"
 
 var errLagMissing = errors.New("lag is missing")
 
+// lineError is returned when a line of output could not be parsed. Topic and
+// Partition are set if the line matched at all.
+type lineError struct {
+	Topic     string
+	Partition string
+	Line      string
+	Err       error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("%s. line: %s", e.Err, e.Line)
+}
+
+// retryableOutputs are substrings of `kafka-consumer-groups.sh` output that
+// indicate a transient error, typically while a group is rebalancing or its
+// coordinator is moving.
+var retryableOutputs = []string{
+	"is rebalancing",
+	"COORDINATOR_NOT_AVAILABLE",
+	"CoordinatorNotAvailableException",
+	"NOT_COORDINATOR",
+	"NotCoordinatorException",
+	"COORDINATOR_LOAD_IN_PROGRESS",
+	"CoordinatorLoadInProgressException",
+}
+
+// stdoutErrorLine matches the lines of standard output that report an error
+// rather than carry data, such as a group or topic name.
+var stdoutErrorLine = regexp.MustCompile("^(Error|Consumer group `.*` is rebalancing)")
+
+// isRetryableOutput returns whether output contains an error that is likely to
+// go away if the command is run again. Only standard error and the error lines
+// of standard output are looked at, so that listing a group whose name
+// happens to contain an error message is not retried.
+func isRetryableOutput(output CommandOutput) bool {
+	errorOutput := []string{output.Stderr}
+	for _, line := range strings.Split(output.Stdout, "\n") {
+		if stdoutErrorLine.MatchString(line) {
+			errorOutput = append(errorOutput, line)
+		}
+	}
+	for _, retryable := range retryableOutputs {
+		for _, text := range errorOutput {
+			if strings.Contains(text, retryable) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func parseGroups(output CommandOutput) ([]string, error) {
 	if strings.Contains(output.Stderr, "java.lang.RuntimeException") {
 		return nil, fmt.Errorf("Got runtime error when executing script. Output: %s", output)
@@ -32,6 +85,11 @@ func parseGroups(output CommandOutput) ([]string, error) {
 }
 
 type regexpParser struct {
+	// name identifies the parser, such as the Kafka release it parses the
+	// output of.
+	name string
+	// formatVersion is the Kafka release that introduced the format.
+	formatVersion string
 	// header is the expected format of the first line. Used as fingerprint to distinguish if this parser is the right one.
 	header *regexp.Regexp
 	// line is the regexp used for the remaining lines of the output (as long as Header matched correctly).
@@ -40,19 +98,49 @@ type regexpParser struct {
 	indexByName map[string]int
 }
 
-func newRegexpParser(header, line *regexp.Regexp) (*regexpParser, error) {
-	indexByName := make(map[string]int)
-	for i, name := range line.SubexpNames() {
-		indexByName[name] = i
+// requiredLineGroups are the named capturing groups the line regexp of a
+// regexpParser must have.
+var requiredLineGroups = []string{"topic", "partitionId", "currentOffset", "lag", "clientId", "consumerAddress"}
+
+// NewRegexpParser returns the parser defined by config, for output whose first
+// line matches config.Header and whose other lines match config.Line.
+// config.Line must have the named capturing groups topic, partitionId,
+// currentOffset, lag, clientId and consumerAddress.
+func NewRegexpParser(config ParserConfig) (DescribeGroupParser, error) {
+	if config.Name == "" {
+		return nil, errors.New("parser name is empty")
+	}
+	headerRegexp, err := regexp.Compile(config.Header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header regexp: %s", err)
+	}
+	lineRegexp, err := regexp.Compile(config.Line)
+	if err != nil {
+		return nil, fmt.Errorf("invalid line regexp: %s", err)
 	}
+	return newRegexpParser(config.Name, config.FormatVersion, headerRegexp, lineRegexp)
+}
 
-	for _, field := range []string{"topic", "partitionId", "currentOffset", "lag", "clientId", "consumerAddress"} {
-		if _, exists := indexByName[field]; !exists {
+func newRegexpParser(name, formatVersion string, header, line *regexp.Regexp) (*regexpParser, error) {
+	parser := builtinParser(name, formatVersion, header, line)
+	for _, field := range requiredLineGroups {
+		if _, exists := parser.indexByName[field]; !exists {
 			return nil, fmt.Errorf("line regexp missing '%s' capturing group", field)
 		}
 	}
+	return parser, nil
+}
 
-	return &regexpParser{header.Copy(), line.Copy(), indexByName}, nil
+// builtinParser returns a parser for one of the formats known. Unlike
+// newRegexpParser, it does not check the capturing groups of line, so that
+// importing the package can never fail. TestBuiltinParsers checks them
+// instead.
+func builtinParser(name, formatVersion string, header, line *regexp.Regexp) *regexpParser {
+	indexByName := make(map[string]int)
+	for i, group := range line.SubexpNames() {
+		indexByName[group] = i
+	}
+	return &regexpParser{name, formatVersion, header.Copy(), line.Copy(), indexByName}
 }
 
 func (p *regexpParser) Parse(output CommandOutput) ([]exporter.PartitionInfo, error) {
@@ -68,20 +156,41 @@ func (p *regexpParser) Parse(output CommandOutput) ([]exporter.PartitionInfo, er
 	}
 
 	partitions := make([]exporter.PartitionInfo, 0, len(dataLines))
-	var err error
-	var partition *exporter.PartitionInfo
+	var badLines []*lineError
 	for _, line := range dataLines {
-		partition, err = p.parseLine(line)
+		partition, err := p.parseLine(line)
 		if err == errLagMissing {
-			err = nil
 			continue
 		}
-		if err != nil {
-			break
+		switch err := err.(type) {
+		case nil:
+			partitions = append(partitions, *partition)
+		case *lineError:
+			badLines = append(badLines, err)
 		}
-		partitions = append(partitions, *partition)
 	}
-	return partitions, err
+	if len(badLines) > 0 {
+		return partitions, &badLinesError{badLines}
+	}
+	return partitions, nil
+}
+
+// badLinesError is returned by a parser that recognized the output, but could
+// not parse some of its lines. It is returned along with the partitions of
+// all other lines.
+type badLinesError struct {
+	lines []*lineError
+}
+
+func (e *badLinesError) Error() string {
+	if len(e.lines) == 1 {
+		return e.lines[0].Error()
+	}
+	return fmt.Sprintf("%d lines could not be parsed, the first: %s", len(e.lines), e.lines[0])
+}
+
+func (e *badLinesError) Unwrap() error {
+	return e.lines[0]
 }
 
 func removeEmptyLines(s []string) []string {
@@ -95,57 +204,52 @@ func removeEmptyLines(s []string) []string {
 }
 
 func (p *regexpParser) String() string {
-	return fmt.Sprintf("regexpParser{header: `%s`, line: `%s`}", p.header, p.line)
+	return p.name
 }
 
 func (p *regexpParser) parseLine(line string) (*exporter.PartitionInfo, error) {
 	matches := p.line.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, &lineError{Line: line, Err: errors.New("line does not match")}
+	}
+	topic := matches[p.indexByName["topic"]]
+	partitionID := matches[p.indexByName["partitionId"]]
 
-	var err error
-
-	var lag int64
-	if lagIndex := p.indexByName["lag"]; lagIndex >= len(matches) {
-		lag = -1
-		err = fmt.Errorf("unable to find current offset field. line: %s", line)
-		log.Warn(err.Error())
-	} else if matches[lagIndex] == missingColumnValue {
+	lagValue := matches[p.indexByName["lag"]]
+	if lagValue == missingColumnValue {
 		// This happens when there are more consumers than partitions.
 		return nil, errLagMissing
-	} else {
-		lag, err = parseLong(matches[lagIndex])
 	}
+	lag, err := parseLong(lagValue)
 	if err != nil {
-		log.Warn("unable to parse int for lag. line: %s", line)
+		return nil, &lineError{topic, partitionID, line, fmt.Errorf("unable to parse int for lag: %s", err)}
 	}
 
-	var currentOffset int64
-	if currentOffsetIndex := p.indexByName["currentOffset"]; currentOffsetIndex >= len(matches) {
-		currentOffset = -1
-		err = fmt.Errorf("unable to find current offset field. Line: %s", line)
-		log.Warn(err.Error())
-	} else {
-		currentOffset, err = parseLong(matches[currentOffsetIndex])
-	}
+	currentOffset, err := parseLong(matches[p.indexByName["currentOffset"]])
 	if err != nil {
-		log.Warn("unable to parse int for current offset. Line: %s", line)
+		return nil, &lineError{topic, partitionID, line, fmt.Errorf("unable to parse int for current offset: %s", err)}
 	}
 
 	partitionInfo := &exporter.PartitionInfo{
-		Topic:           matches[p.indexByName["topic"]],
-		PartitionID:     matches[p.indexByName["partitionId"]],
+		Topic:           topic,
+		PartitionID:     partitionID,
 		CurrentOffset:   currentOffset,
 		Lag:             lag,
 		ClientID:        matches[p.indexByName["clientId"]],
 		ConsumerAddress: matches[p.indexByName["consumerAddress"]],
 	}
 
-	return partitionInfo, err
+	return partitionInfo, nil
 }
 
+// parseLong parses an offset or lag, which can't be negative.
 func parseLong(value string) (int64, error) {
 	longVal, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		return -1, err
+		return 0, err
+	}
+	if longVal < 0 {
+		return 0, fmt.Errorf("negative value %d", longVal)
 	}
 	return longVal, nil
 }
@@ -153,68 +257,263 @@ func parseLong(value string) (int64, error) {
 // Parsers for "describe group" output.
 var (
 	// Parser for Kafka 0.10.2.1. Since we are unsure if the column widths are dynamic, we are using `\s+` for delimiters.
-	kafka0_10_2_1DescribeGroupParser = mustBuildNewRegexpParser(
+	kafka0_10_2_1DescribeGroupParser = builtinParser(
+		"kafka-0.10.2.1",
+		"0.10.2.1",
 		regexp.MustCompile(`TOPIC\s+PARTITION\s+CURRENT-OFFSET\s+LOG-END-OFFSET\s+LAG\s+CONSUMER-ID\s+HOST\s+CLIENT-ID`),
 		regexp.MustCompile(`(?P<topic>[a-zA-Z0-9\\._\\-]+)\s+(?P<partitionId>\d+|-)\s+(?P<currentOffset>\d+|-)\s+(\d+|-)\s+(?P<lag>\d+|-)\s+(?P<consumerId>[^/\s]+)\s*/?(?P<consumerAddress>\S+)\s+(?P<clientId>\S+)`),
 	)
 
 	// Parser for Kafka 0.10.1.X.
-	kafka0_10_1DescribeGroupParser = mustBuildNewRegexpParser(
+	kafka0_10_1DescribeGroupParser = builtinParser(
+		"kafka-0.10.1",
+		"0.10.1",
 		regexp.MustCompile(`GROUP\s+TOPIC\s+PARTITION\s+CURRENT-OFFSET\s+LOG-END-OFFSET\s+LAG\s+OWNER`),
 		regexp.MustCompile(`.+\s+(?P<topic>[a-zA-Z0-9\\._\\-]+)\s+(?P<partitionId>\d+)\s+(?P<currentOffset>\d+)\s+\d+\s+(?P<lag>\d+)\s+(?P<clientId>\S+)_/(?P<consumerAddress>.+)`),
 	)
 
 	// Parser for Kafka 0.10.0.1. Since we are unsure if the column widths are dynamic, we are using `\s+` for delimiters.
-	kafka0_10_0_1DescribeGroupParser = mustBuildNewRegexpParser(
+	kafka0_10_0_1DescribeGroupParser = builtinParser(
+		"kafka-0.10.0.1",
+		"0.10.0.1",
 		regexp.MustCompile(`GROUP\s+TOPIC\s+PARTITION\s+CURRENT-OFFSET\s+LOG-END-OFFSET\s+LAG\s+OWNER`),
 		regexp.MustCompile(`.+\s+(?P<topic>[a-zA-Z0-9\\._\\-]+)\s+(?P<partitionId>\d+)\s+(?P<currentOffset>\d+)\s+\d+\s+(?P<lag>\d+)\s+(?P<clientId>\S+)_/(?P<consumerAddress>.+)`),
 	)
-	kafka0_9_0_1DescribeGroupParser = mustBuildNewRegexpParser(
+	// Parser for Kafka 0.9.0.1.
+	kafka0_9_0_1DescribeGroupParser = builtinParser(
+		"kafka-0.9.0.1",
+		"0.9.0.1",
 		regexp.MustCompile("GROUP, TOPIC, PARTITION, CURRENT OFFSET, LOG END OFFSET, LAG, OWNER"),
 		regexp.MustCompile(`[^,]+, (?P<topic>[a-zA-Z0-9\\._\\-]+), (?P<partitionId>\d+), (?P<currentOffset>\d+), \d+, (?P<lag>\d+), (?P<clientId>.+)_/(?P<consumerAddress>.+)`),
 	)
 )
 
-func mustBuildNewRegexpParser(header, line *regexp.Regexp) *regexpParser {
-	parser, err := newRegexpParser(header, line)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return parser
+// builtinParsers are the parsers for all formats known, in the order they are
+// tried by default.
+var builtinParsers = []*regexpParser{
+	kafka0_9_0_1DescribeGroupParser,
+	kafka0_10_0_1DescribeGroupParser,
+	kafka0_10_1DescribeGroupParser,
+	kafka0_10_2_1DescribeGroupParser,
 }
 
 // DefaultDescribeGroupParser returns a DelegatingParser consisting of all formats known.
 func DefaultDescribeGroupParser() *DelegatingParser {
-	return &DelegatingParser{
-		[]DescribeGroupParser{
-			kafka0_9_0_1DescribeGroupParser,
-			kafka0_10_0_1DescribeGroupParser,
-			kafka0_10_1DescribeGroupParser,
-			kafka0_10_2_1DescribeGroupParser,
-		},
+	parsers := make([]DescribeGroupParser, 0, len(builtinParsers))
+	for _, parser := range builtinParsers {
+		parsers = append(parsers, parser)
 	}
+	return &DelegatingParser{Parsers: parsers}
+}
+
+// ParseMode decides what a DelegatingParser does with output of which some
+// lines cannot be parsed.
+type ParseMode int
+
+const (
+	// ParseStrict rejects the whole output.
+	ParseStrict ParseMode = iota
+	// ParseLenient skips the lines that cannot be parsed, and counts them.
+	ParseLenient
+)
+
+// ParseParseMode parses the name of a ParseMode: "strict" or "lenient".
+func ParseParseMode(name string) (ParseMode, error) {
+	switch name {
+	case "strict":
+		return ParseStrict, nil
+	case "lenient":
+		return ParseLenient, nil
+	}
+	return 0, fmt.Errorf("unknown parse mode %q", name)
 }
 
 // DelegatingParser is a parser that tries multiple parser returning the first
 // succesful parsed result.
+//
+// It remembers which parser last succeeded for each cluster and tries it
+// first, until it fails. It is also a prometheus.Collector, exporting which
+// parser that is.
 type DelegatingParser struct {
 	Parsers []DescribeGroupParser
+	// Mode decides what happens to output with lines that cannot be parsed.
+	// It defaults to ParseStrict.
+	Mode ParseMode
+
+	mu sync.Mutex
+	// chosen holds the parser that last succeeded by cluster.
+	chosen map[string]*chosenParser
+	// skippedLines counts the lines skipped in ParseLenient mode by cluster.
+	// Not by group, since groups come and go and would never be forgotten;
+	// the skipped lines of each group are logged instead.
+	skippedLines map[string]float64
+}
+
+// chosenParser is the parser that last succeeded for a cluster.
+type chosenParser struct {
+	parser DescribeGroupParser
+	// failed is set once the parser failed after succeeding, so that it is
+	// not tried first anymore.
+	failed bool
 }
 
+var parserInfoDesc = prometheus.NewDesc(
+	"kafka_consumer_group_exporter_parser_info",
+	"The parser that last parsed the output of `kafka-consumer-groups.sh --describe` for a cluster, and the Kafka release that introduced its format.",
+	[]string{"cluster", "parser", "format_version"},
+	nil,
+)
+
+var skippedLinesDesc = prometheus.NewDesc(
+	"kafka_consumer_group_parse_skipped_lines",
+	"Number of lines of `kafka-consumer-groups.sh --describe` output that could not be parsed and were skipped in lenient mode.",
+	[]string{"cluster"},
+	nil,
+)
+
 // Parse parses the output. It tries each Parser in order, returning an error
 // if all fails.
 func (p *DelegatingParser) Parse(output CommandOutput) ([]exporter.PartitionInfo, error) {
-	var err error
-	var partitions []exporter.PartitionInfo
+	partitions, _, err := p.ParseMatching(output)
+	return partitions, err
+}
 
+// ParseMatching parses the output like Parse, and also returns the parser
+// that parsed it. If a parser recognized the output but failed to parse a
+// line, the returned error wraps a *lineError.
+func (p *DelegatingParser) ParseMatching(output CommandOutput) ([]exporter.PartitionInfo, DescribeGroupParser, error) {
+	var lineErr *lineError
+	first := p.first(output.Cluster)
+	if first != nil {
+		partitions, err := p.parseWith(first, output)
+		if err == nil {
+			return partitions, first, nil
+		}
+		errors.As(err, &lineErr)
+		p.failed(output.Cluster, first)
+	}
 	for _, parser := range p.Parsers {
-		partitions, err = parser.Parse(output)
+		if parser == first {
+			continue
+		}
+		partitions, err := p.parseWith(parser, output)
 		if err == nil {
-			return partitions, err
+			p.succeeded(output.Cluster, parser)
+			return partitions, parser, nil
 		}
+		if lineErr == nil {
+			errors.As(err, &lineErr)
+		}
+	}
+
+	if lineErr != nil {
+		return nil, nil, fmt.Errorf("no parser could parse the output: %w", lineErr)
+	}
+	return nil, nil, errors.New("no parser could parse the output")
+}
+
+// parseWith parses the output with parser. In ParseLenient mode, lines that
+// cannot be parsed are skipped, unless no line can be parsed at all, which
+// means that parser is likely the wrong one.
+func (p *DelegatingParser) parseWith(parser DescribeGroupParser, output CommandOutput) ([]exporter.PartitionInfo, error) {
+	partitions, err := parser.Parse(output)
+	var badLines *badLinesError
+	if err == nil || p.Mode != ParseLenient || !errors.As(err, &badLines) || len(partitions) == 0 {
+		return partitions, err
+	}
+
+	p.mu.Lock()
+	if p.skippedLines == nil {
+		p.skippedLines = make(map[string]float64)
+	}
+	p.skippedLines[output.Cluster] += float64(len(badLines.lines))
+	p.mu.Unlock()
+	warnParseError(output.Cluster, output.Group, err, "Skipped lines of group description")
+	return partitions, nil
+}
+
+// first returns the parser to try first for cluster, or nil if there is
+// none.
+func (p *DelegatingParser) first(cluster string) DescribeGroupParser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if chosen, ok := p.chosen[cluster]; ok && !chosen.failed {
+		return chosen.parser
 	}
+	return nil
+}
+
+func (p *DelegatingParser) failed(cluster string, parser DescribeGroupParser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if chosen, ok := p.chosen[cluster]; ok && chosen.parser == parser {
+		chosen.failed = true
+	}
+}
+
+func (p *DelegatingParser) succeeded(cluster string, parser DescribeGroupParser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.chosen == nil {
+		p.chosen = make(map[string]*chosenParser)
+	}
+	previous, ok := p.chosen[cluster]
+	if ok && previous.parser == parser {
+		previous.failed = false
+		return
+	}
+	entry := log.WithFields(log.Fields{"cluster": cluster, "parser": parser, "format_version": formatVersion(parser)})
+	if ok {
+		entry = entry.WithField("previous_parser", previous.parser)
+	}
+	entry.Info("Detected describe output format")
+	p.chosen[cluster] = &chosenParser{parser: parser}
+}
+
+// formatVersion returns the Kafka release that introduced the format parser
+// parses, or an empty string if it is not known.
+func formatVersion(parser DescribeGroupParser) string {
+	if regexpParser, ok := parser.(*regexpParser); ok {
+		return regexpParser.formatVersion
+	}
+	return ""
+}
+
+// Describe implements prometheus.Collector.
+func (p *DelegatingParser) Describe(c chan<- *prometheus.Desc) {
+	c <- parserInfoDesc
+	c <- skippedLinesDesc
+}
 
-	return nil, errors.New("no parser could parse the output")
+// Collect implements prometheus.Collector.
+func (p *DelegatingParser) Collect(c chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for cluster, chosen := range p.chosen {
+		c <- prometheus.MustNewConstMetric(parserInfoDesc, prometheus.GaugeValue, 1,
+			cluster, fmt.Sprint(chosen.parser), formatVersion(chosen.parser))
+	}
+	for cluster, skipped := range p.skippedLines {
+		c <- prometheus.MustNewConstMetric(skippedLinesDesc, prometheus.CounterValue, skipped, cluster)
+	}
+}
+
+// ParserResult is what a single parser made of some output.
+type ParserResult struct {
+	Parser     DescribeGroupParser
+	Partitions []exporter.PartitionInfo
+	Err        error
+}
+
+// ParseEach parses the output with every parser, rather than stopping at the
+// first one that succeeds, to find out why parsers don't match.
+func (p *DelegatingParser) ParseEach(output CommandOutput) []ParserResult {
+	results := make([]ParserResult, 0, len(p.Parsers))
+	for _, parser := range p.Parsers {
+		partitions, err := parser.Parse(output)
+		results = append(results, ParserResult{parser, partitions, err})
+	}
+	return results
 }
 
 func (p *DelegatingParser) String() string {
@@ -1,9 +1,12 @@
 package kafka
 
 import (
+	"errors"
+	"strings"
 	. "testing"
 
 	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestParsingPartitionTableForKafkaVersion0_10_2_1(t *T) {
@@ -377,6 +380,217 @@ java.lang.RuntimeException: Request METADATA failed on brokers List(localhost:90
 	}
 }
 
+func TestRetryableOutputClassification(t *T) {
+	retryable := []CommandOutput{
+		{Stdout: "Consumer group `my-group` is rebalancing.\n"},
+		{Stdout: "Error: Executing consumer group command failed due to NOT_COORDINATOR\n"},
+		{Stderr: "Error: Executing consumer group command failed due to org.apache.kafka.common.errors.CoordinatorNotAvailableException: The coordinator is not available.\n"},
+		{Stderr: "Error: Executing consumer group command failed due to COORDINATOR_NOT_AVAILABLE\n"},
+		{Stderr: "Error: Executing consumer group command failed due to NOT_COORDINATOR\n"},
+		{Stderr: "Error: Executing consumer group command failed due to COORDINATOR_LOAD_IN_PROGRESS\n"},
+	}
+	for _, output := range retryable {
+		if !isRetryableOutput(output) {
+			t.Error("Expected output to be retryable:", output)
+		}
+	}
+
+	permanent := []CommandOutput{
+		{Stdout: "TOPIC PARTITION CURRENT-OFFSET LOG-END-OFFSET LAG CONSUMER-ID HOST CLIENT-ID\n"},
+		{Stderr: "Error while executing consumer group command Request METADATA failed on brokers List(localhost:9042 (id: -1 rack: null))\n"},
+		{Stderr: "Consumer group `my-group` does not exist.\n"},
+		// Group names listed on standard output are data, not errors.
+		{Stdout: "orders\norders-is rebalancing\nNOT_COORDINATOR\n"},
+	}
+	for _, output := range permanent {
+		if isRetryableOutput(output) {
+			t.Error("Expected output to not be retryable:", output)
+		}
+	}
+}
+
 func TestInterfaceImplementation(t *T) {
 	var _ exporter.ConsumerGroupInfoClient = (*ConsumerGroupsCommandClient)(nil)
 }
+
+func TestBuiltinParsers(t *T) {
+	for _, parser := range builtinParsers {
+		if _, err := newRegexpParser(parser.name, parser.formatVersion, parser.header, parser.line); err != nil {
+			t.Errorf("Invalid built-in parser %s: %s", parser, err)
+		}
+	}
+}
+
+// prefixParser parses output starting with prefix, and counts its calls.
+type prefixParser struct {
+	prefix string
+	calls  int
+}
+
+func (p *prefixParser) Parse(output CommandOutput) ([]exporter.PartitionInfo, error) {
+	p.calls++
+	if !strings.HasPrefix(output.Stdout, p.prefix) {
+		return nil, errors.New("incorrect header")
+	}
+	return []exporter.PartitionInfo{{Topic: p.prefix}}, nil
+}
+
+func (p *prefixParser) String() string {
+	return p.prefix
+}
+
+func TestDelegatingParserRemembersParserPerCluster(t *T) {
+	old, current := &prefixParser{prefix: "old"}, &prefixParser{prefix: "current"}
+	parser := &DelegatingParser{Parsers: []DescribeGroupParser{old, current}}
+	parse := func(cluster, stdout string, expectedOld, expectedCurrent int) {
+		t.Helper()
+		partitions, err := parser.Parse(CommandOutput{Stdout: stdout, Cluster: cluster})
+		if err != nil || partitions[0].Topic != stdout {
+			t.Error("Unexpected result:", partitions, err)
+		}
+		if old.calls != expectedOld || current.calls != expectedCurrent {
+			t.Errorf("Expected %d and %d calls. Got: %d and %d", expectedOld, expectedCurrent, old.calls, current.calls)
+		}
+	}
+
+	parse("a", "current", 1, 1)
+	parse("a", "current", 1, 2)
+	parse("b", "old", 2, 2)
+	// The format of cluster a changes.
+	parse("a", "old", 3, 3)
+	parse("a", "old", 4, 3)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(parser)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(families) != 1 || len(families[0].Metric) != 2 {
+		t.Fatal("Expected the parser of each cluster to be exported. Got:", families)
+	}
+	for _, metric := range families[0].Metric {
+		labels := make(map[string]string)
+		for _, label := range metric.Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["parser"] != "old" {
+			t.Error("Expected the parser that last succeeded to be exported. Got:", labels)
+		}
+	}
+}
+
+func TestDelegatingParserExportsFormatVersion(t *T) {
+	parser := DefaultDescribeGroupParser()
+	output := CommandOutput{Cluster: "kafka:9092", Stdout: `TOPIC                          PARTITION  CURRENT-OFFSET  LOG-END-OFFSET  LAG        CONSUMER-ID                                       HOST                           CLIENT-ID
+topic1           0          3545            3547            2          consumer-1-583b2298-f285-4696-ba0e-576109284592   /10.21.95.43                   consumer-1`}
+	if _, err := parser.Parse(output); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(parser)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := map[string]string{"cluster": "kafka:9092", "parser": "kafka-0.10.2.1", "format_version": "0.10.2.1"}
+	for _, label := range families[0].Metric[0].Label {
+		if expected[label.GetName()] != label.GetValue() {
+			t.Error("Unexpected label:", label)
+		}
+	}
+}
+
+func TestParseModes(t *T) {
+	output := CommandOutput{Cluster: "kafka:9092", Group: "orders", Stdout: `TOPIC                          PARTITION  CURRENT-OFFSET  LOG-END-OFFSET  LAG        CONSUMER-ID                                       HOST                           CLIENT-ID
+topic1           0          3545            3547            2          consumer-1-583b2298-f285-4696-ba0e-576109284592   /10.21.95.43                   consumer-1
+topic1           1          -               3547            2          consumer-1-583b2298-f285-4696-ba0e-576109284592   /10.21.95.43                   consumer-1`}
+
+	strict := DefaultDescribeGroupParser()
+	if _, err := strict.Parse(output); err == nil {
+		t.Error("Expected the strict mode to reject the group.")
+	}
+
+	lenient := DefaultDescribeGroupParser()
+	lenient.Mode = ParseLenient
+	partitions, err := lenient.Parse(output)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(partitions) != 1 || partitions[0].PartitionID != "0" {
+		t.Error("Expected the bad line to be skipped. Got:", partitions)
+	}
+
+	// Counted by cluster only, so that groups that are gone aren't
+	// remembered.
+	other := output
+	other.Group = "payments"
+	lenient.Parse(other)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(lenient)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	found := false
+	for _, family := range families {
+		if family.GetName() != "kafka_consumer_group_parse_skipped_lines" {
+			continue
+		}
+		found = len(family.Metric) == 1 && family.Metric[0].Counter.GetValue() == 2 &&
+			len(family.Metric[0].Label) == 1 && family.Metric[0].Label[0].GetValue() == "kafka:9092"
+	}
+	if !found {
+		t.Error("Expected the skipped lines of both groups to be counted for the cluster. Got:", families)
+	}
+
+	garbage := output
+	garbage.Stdout = strings.SplitN(output.Stdout, "\n", 2)[0] + "\ngarbage"
+	if _, err := lenient.Parse(garbage); err == nil {
+		t.Error("Expected output without any parseable line to be rejected.")
+	}
+}
+
+func TestParseModesHandleEveryBadLine(t *T) {
+	header := `TOPIC                          PARTITION  CURRENT-OFFSET  LOG-END-OFFSET  LAG        CONSUMER-ID                                       HOST                           CLIENT-ID`
+	good := `topic1           0          3545            3547            2          consumer-1-583b2298-f285-4696-ba0e-576109284592   /10.21.95.43                   consumer-1`
+	for name, bad := range map[string]string{
+		"unmatched":       `garbage`,
+		"missing offset":  `topic1           1          -               3547            2          consumer-1-583b2298-f285-4696-ba0e-576109284592   /10.21.95.43                   consumer-1`,
+		"overflowing lag": `topic1           1          3545            3547            99999999999999999999          consumer-1-583b2298-f285-4696-ba0e-576109284592   /10.21.95.43                   consumer-1`,
+	} {
+		output := CommandOutput{Cluster: "kafka:9092", Group: "orders", Stdout: header + "\n" + good + "\n" + bad}
+
+		partitions, err := DefaultDescribeGroupParser().Parse(output)
+		var lineErr *lineError
+		if !errors.As(err, &lineErr) || lineErr.Line != bad || len(partitions) != 0 {
+			t.Errorf("Expected the strict mode to reject the group with the %s line. Got: %v %v", name, partitions, err)
+		}
+
+		lenient := DefaultDescribeGroupParser()
+		lenient.Mode = ParseLenient
+		partitions, err = lenient.Parse(output)
+		if err != nil || len(partitions) != 1 || partitions[0].PartitionID != "0" {
+			t.Errorf("Expected the lenient mode to skip the %s line. Got: %v %v", name, partitions, err)
+		}
+	}
+}
+
+func TestParsingNeverReturnsNegativeValues(t *T) {
+	parser, err := NewRegexpParser(ParserConfig{
+		Name:   "signed",
+		Header: "TOPIC",
+		Line:   `(?P<topic>\S+) (?P<partitionId>\d+) (?P<currentOffset>-?\d+) (?P<lag>-?\d+) (?P<clientId>\S+) (?P<consumerAddress>\S+)`,
+	})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	for _, line := range []string{"orders 0 -1 5 consumer-1 host", "orders 0 5 -1 consumer-1 host"} {
+		partitions, err := parser.Parse(CommandOutput{Stdout: "TOPIC\n" + line})
+		if err == nil || len(partitions) != 0 {
+			t.Errorf("Expected %q to be rejected. Got: %v", line, partitions)
+		}
+	}
+}
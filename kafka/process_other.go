@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package kafka
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startInProcessGroup makes cmd start in a process group of its own, so that
+// killProcessGroup also reaches the JVM started by the script.
+func startInProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills cmd and all processes it started.
+func killProcessGroup(cmd *exec.Cmd) {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,11 @@
+package kafka
+
+import "os/exec"
+
+// startInProcessGroup is a no-op, as Windows has no process groups to kill.
+func startInProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd. Processes it started keep running.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
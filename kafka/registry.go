@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ParserConfig defines a parser for a describe group output format that is
+// not known, such as the one of a patched Kafka release. See
+// NewRegexpParser for the requirements on Header and Line.
+type ParserConfig struct {
+	Name string `yaml:"name"`
+	// FormatVersion is the Kafka release the format was introduced in, if
+	// known. It is exported by the parser info metric.
+	FormatVersion string `yaml:"format_version"`
+	Header        string `yaml:"header"`
+	Line          string `yaml:"line"`
+}
+
+// ParserRegistry holds describe group parsers by name. It starts out with
+// the parsers for all formats known.
+type ParserRegistry struct {
+	names   []string
+	parsers map[string]DescribeGroupParser
+}
+
+// NewParserRegistry returns a ParserRegistry holding the parsers for all
+// formats known.
+func NewParserRegistry() *ParserRegistry {
+	r := &ParserRegistry{parsers: make(map[string]DescribeGroupParser)}
+	for _, parser := range builtinParsers {
+		r.names = append(r.names, parser.name)
+		r.parsers[parser.name] = parser
+	}
+	return r
+}
+
+// Register adds parser under name, which must not be taken already.
+func (r *ParserRegistry) Register(name string, parser DescribeGroupParser) error {
+	if name == "" {
+		return errors.New("parser name is empty")
+	}
+	if _, ok := r.parsers[name]; ok {
+		return fmt.Errorf("parser %q is already registered", name)
+	}
+	r.names = append(r.names, name)
+	r.parsers[name] = parser
+	return nil
+}
+
+// RegisterConfig builds the parsers defined by configs and adds them. If any
+// of them is invalid, none are added.
+func (r *ParserRegistry) RegisterConfig(configs []ParserConfig) error {
+	parsers := make([]DescribeGroupParser, len(configs))
+	seen := make(map[string]bool)
+	for i, config := range configs {
+		if _, ok := r.parsers[config.Name]; ok || seen[config.Name] {
+			return fmt.Errorf("parser %q is already registered", config.Name)
+		}
+		seen[config.Name] = true
+		parser, err := NewRegexpParser(config)
+		if err != nil {
+			return fmt.Errorf("invalid parser %q: %s", config.Name, err)
+		}
+		parsers[i] = parser
+	}
+	for i, parser := range parsers {
+		r.Register(configs[i].Name, parser)
+	}
+	return nil
+}
+
+// Names returns the names of all parsers in the order they were added.
+func (r *ParserRegistry) Names() []string {
+	return append([]string(nil), r.names...)
+}
+
+// DelegatingParser returns a DelegatingParser trying the parsers with the
+// given names in order, or all parsers in the order they were added if no
+// names are given.
+func (r *ParserRegistry) DelegatingParser(names ...string) (*DelegatingParser, error) {
+	if len(names) == 0 {
+		names = r.names
+	}
+	parsers := make([]DescribeGroupParser, 0, len(names))
+	for _, name := range names {
+		parser, ok := r.parsers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown parser %q", name)
+		}
+		parsers = append(parsers, parser)
+	}
+	return &DelegatingParser{Parsers: parsers}, nil
+}
+
+// ParsersConfig is the parser configuration file, defining extra parsers and
+// the order all parsers are tried in:
+//
+//	parsers:
+//	  - name: patched-2.8
+//	    header: 'GROUP\s+TOPIC\s+PARTITION\s+...'
+//	    line: '(?P<topic>\S+)\s+(?P<partitionId>\d+)\s+...'
+//	order: [patched-2.8, kafka-0.10.2.1]
+type ParsersConfig struct {
+	Parsers []ParserConfig `yaml:"parsers"`
+	// Order lists the names of the parsers to try, in order. If empty, the
+	// parsers defined in the file are tried first, then the built-in ones.
+	Order []string `yaml:"order"`
+}
+
+// LoadParsersConfig reads a ParsersConfig from path.
+func LoadParsersConfig(path string) (*ParsersConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config ParsersConfig
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("invalid parser config %s: %s", path, err)
+	}
+	return &config, nil
+}
+
+// DelegatingParser returns a DelegatingParser trying the configured parsers
+// in the configured order.
+func (c *ParsersConfig) DelegatingParser() (*DelegatingParser, error) {
+	r := NewParserRegistry()
+	if err := r.RegisterConfig(c.Parsers); err != nil {
+		return nil, err
+	}
+	order := c.Order
+	if len(order) == 0 {
+		for _, config := range c.Parsers {
+			order = append(order, config.Name)
+		}
+		for _, parser := range builtinParsers {
+			order = append(order, parser.name)
+		}
+	}
+	return r.DelegatingParser(order...)
+}
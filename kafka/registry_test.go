@@ -0,0 +1,137 @@
+package kafka
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	. "testing"
+)
+
+func TestParserRegistry(t *T) {
+	r := NewParserRegistry()
+	builtins := []string{"kafka-0.9.0.1", "kafka-0.10.0.1", "kafka-0.10.1", "kafka-0.10.2.1"}
+	if names := r.Names(); !reflect.DeepEqual(names, builtins) {
+		t.Error("Unexpected built-in parsers:", names)
+	}
+
+	err := r.RegisterConfig([]ParserConfig{{
+		Name:   "patched",
+		Header: `PARTITION\s+TOPIC`,
+		Line:   `(?P<partitionId>\d+)\s+(?P<topic>\S+)\s+(?P<currentOffset>\d+)\s+(?P<lag>\d+)\s+(?P<clientId>\S+)\s+(?P<consumerAddress>\S+)`,
+	}})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	parser, err := r.DelegatingParser("patched", "kafka-0.10.2.1")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	partitions, err := parser.Parse(CommandOutput{Stdout: "PARTITION TOPIC\n3 orders 10 2 consumer-1 /10.0.0.1\n"})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(partitions) != 1 || partitions[0].Topic != "orders" || partitions[0].PartitionID != "3" || partitions[0].Lag != 2 {
+		t.Error("Unexpected partitions:", partitions)
+	}
+
+	if _, err := r.DelegatingParser("missing"); err == nil {
+		t.Error("Expected an unknown parser to be rejected.")
+	}
+	if all, err := r.DelegatingParser(); err != nil || len(all.Parsers) != len(builtins)+1 {
+		t.Error("Expected all parsers by default. Got:", all, err)
+	}
+}
+
+func TestParserRegistryRejectsInvalidConfig(t *T) {
+	valid := `(?P<topic>\S+) (?P<partitionId>\d+) (?P<currentOffset>\d+) (?P<lag>\d+) (?P<clientId>\S+) (?P<consumerAddress>\S+)`
+	for _, test := range []struct {
+		config   ParserConfig
+		expected string
+	}{
+		{ParserConfig{Name: "", Header: "TOPIC", Line: valid}, "name is empty"},
+		{ParserConfig{Name: "kafka-0.10.2.1", Header: "TOPIC", Line: valid}, "already registered"},
+		{ParserConfig{Name: "broken", Header: "TOPIC(", Line: valid}, "invalid header regexp"},
+		{ParserConfig{Name: "broken", Header: "TOPIC", Line: valid + "("}, "invalid line regexp"},
+		{ParserConfig{Name: "broken", Header: "TOPIC", Line: `(?P<topic>\S+)`}, "missing 'partitionId' capturing group"},
+	} {
+		r := NewParserRegistry()
+		err := r.RegisterConfig([]ParserConfig{{Name: "good", Header: "TOPIC", Line: valid}, test.config})
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected an error containing %q for %v. Got: %v", test.expected, test.config, err)
+		}
+		if len(r.Names()) != 4 {
+			t.Error("Expected no parser to be added when one is invalid. Got:", r.Names())
+		}
+	}
+
+	r := NewParserRegistry()
+	if err := r.RegisterConfig([]ParserConfig{{Name: "twice", Header: "TOPIC", Line: valid}, {Name: "twice", Header: "TOPIC", Line: valid}}); err == nil {
+		t.Error("Expected duplicate names to be rejected.")
+	}
+}
+
+func TestParsersConfig(t *T) {
+	dir, err := ioutil.TempDir("", "parsers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "parsers.yml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+parsers:
+  - name: patched
+    header: 'PARTITION\s+TOPIC'
+    line: '(?P<partitionId>\d+)\s+(?P<topic>\S+)\s+(?P<currentOffset>\d+)\s+(?P<lag>\d+)\s+(?P<clientId>\S+)\s+(?P<consumerAddress>\S+)'
+`)
+	config, err := LoadParsersConfig(path)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	parser, err := config.DelegatingParser()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(parser.Parsers) != 5 || parser.Parsers[0].(fmt.Stringer).String() != "patched" {
+		t.Error("Expected the configured parser to be tried first. Got:", parser.Parsers)
+	}
+
+	config.Order = []string{"kafka-0.10.2.1", "patched"}
+	if parser, err = config.DelegatingParser(); err != nil || len(parser.Parsers) != 2 || parser.Parsers[0] != kafka0_10_2_1DescribeGroupParser {
+		t.Error("Expected the configured order. Got:", parser, err)
+	}
+	config.Order = []string{"missing"}
+	if _, err := config.DelegatingParser(); err == nil {
+		t.Error("Expected an unknown parser in the order to be rejected.")
+	}
+
+	write("parsers:\n  - name: typo\n    haeder: TOPIC\n")
+	if _, err := LoadParsersConfig(path); err == nil {
+		t.Error("Expected unknown fields to be rejected.")
+	}
+}
+
+func TestParseEach(t *T) {
+	results := DefaultDescribeGroupParser().ParseEach(CommandOutput{Stdout: `TOPIC                          PARTITION  CURRENT-OFFSET  LOG-END-OFFSET  LAG        CONSUMER-ID                                       HOST                           CLIENT-ID
+topic1           0          3545            3547            2          consumer-1-583b2298-f285-4696-ba0e-576109284592   /10.21.95.43                   consumer-1`})
+	if len(results) != len(builtinParsers) {
+		t.Fatal("Expected a result per parser. Got:", results)
+	}
+	for _, result := range results[:len(results)-1] {
+		if result.Err == nil {
+			t.Error("Expected", result.Parser, "to fail.")
+		}
+	}
+	if last := results[len(results)-1]; last.Err != nil || len(last.Partitions) != 1 {
+		t.Error("Expected the last parser to succeed. Got:", last)
+	}
+}
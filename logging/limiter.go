@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter limits how often entries about the same key, such as a consumer
+// group, are logged.
+type Limiter struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu         sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+	forgotten  time.Time
+}
+
+// NewLimiter returns a Limiter allowing one entry per key and interval.
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{
+		interval:   interval,
+		now:        time.Now,
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+// Allow returns whether an entry about key may be logged now, and if so, how
+// many entries about key were suppressed since the last one allowed.
+func (l *Limiter) Allow(key string) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if last, ok := l.last[key]; ok && now.Sub(last) < l.interval {
+		l.suppressed[key]++
+		return false, 0
+	}
+
+	suppressed := l.suppressed[key]
+	delete(l.suppressed, key)
+	l.last[key] = now
+	l.forget(now)
+	return true, suppressed
+}
+
+// forget drops keys that were last allowed more than an interval ago, so that
+// keys which are gone for good don't use memory forever. It scans the keys at
+// most once per interval.
+func (l *Limiter) forget(now time.Time) {
+	if now.Sub(l.forgotten) < l.interval {
+		return
+	}
+	l.forgotten = now
+	for key, last := range l.last {
+		if now.Sub(last) >= l.interval {
+			delete(l.last, key)
+			delete(l.suppressed, key)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLimiter(time.Minute)
+	l.now = func() time.Time { return now }
+
+	if ok, suppressed := l.Allow("group-a"); !ok || suppressed != 0 {
+		t.Error("Expected the first entry to be allowed. Got:", ok, suppressed)
+	}
+	if ok, _ := l.Allow("group-b"); !ok {
+		t.Error("Expected entries about other keys to be allowed.")
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("group-a"); ok {
+			t.Error("Expected repeated entries to be suppressed.")
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if ok, suppressed := l.Allow("group-a"); !ok || suppressed != 3 {
+		t.Error("Expected an entry to be allowed again with the suppressed count. Got:", ok, suppressed)
+	}
+	if _, ok := l.last["group-b"]; ok {
+		t.Error("Expected keys not seen for an interval to be forgotten.")
+	}
+}
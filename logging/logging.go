@@ -0,0 +1,53 @@
+// Package logging configures the logrus standard logger, which all packages
+// log through, and helps keeping repeated log entries in check.
+package logging
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Formats lists the supported log formats.
+var Formats = []string{"logfmt", "json"}
+
+// Configure makes the standard logger log entries of at least level in format,
+// one of Formats. fields are added to all entries that don't set them
+// already.
+func Configure(format, level string, fields log.Fields) error {
+	switch format {
+	case "logfmt":
+		log.SetFormatter(&log.TextFormatter{DisableColors: true, FullTimestamp: true})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	parsedLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(parsedLevel)
+
+	if len(fields) > 0 {
+		log.AddHook(fieldsHook(fields))
+	}
+	return nil
+}
+
+// fieldsHook adds fields to all entries that don't set them already.
+type fieldsHook log.Fields
+
+func (h fieldsHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h fieldsHook) Fire(entry *log.Entry) error {
+	for key, value := range h {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestConfigure(t *testing.T) {
+	logger := log.StandardLogger()
+	formatter, level, hooks, output := logger.Formatter, logger.GetLevel(), logger.Hooks, logger.Out
+	defer func() {
+		logger.SetFormatter(formatter)
+		logger.SetLevel(level)
+		logger.ReplaceHooks(hooks)
+		logger.SetOutput(output)
+	}()
+	var out bytes.Buffer
+	logger.SetOutput(&out)
+
+	if err := Configure("json", "warn", log.Fields{"cluster": "kafka:9092"}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	log.Info("ignored")
+	log.WithField("group", "orders").Warn("kept")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatal("Expected a single JSON entry. Got:", out.String())
+	}
+	if entry["msg"] != "kept" || entry["group"] != "orders" || entry["cluster"] != "kafka:9092" {
+		t.Error("Unexpected entry:", entry)
+	}
+
+	logger.ReplaceHooks(make(log.LevelHooks))
+	out.Reset()
+	if err := Configure("logfmt", "info", nil); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	log.WithField("group", "orders").Info("Could not describe group")
+	if line := out.String(); !strings.Contains(line, `msg="Could not describe group" group=orders`) {
+		t.Error("Unexpected logfmt entry:", line)
+	}
+
+	if err := Configure("xml", "info", nil); err == nil {
+		t.Error("Expected an unknown format to be rejected.")
+	}
+	if err := Configure("json", "loud", nil); err == nil {
+		t.Error("Expected an unknown level to be rejected.")
+	}
+}
@@ -1,6 +1,10 @@
 package exporter
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 // PartitionInfo holds information about a partition in Kafka.
 type PartitionInfo struct {
@@ -10,6 +14,9 @@ type PartitionInfo struct {
 	Lag             int64
 	ClientID        string
 	ConsumerAddress string
+	// FetchedAt is when the partition was described by Kafka, which tells
+	// cached results apart from fresh ones. Zero if unknown.
+	FetchedAt time.Time
 }
 
 // ConsumerGroupInfoClient queries consumer groups and consumer group partitions
@@ -18,3 +25,28 @@ type ConsumerGroupInfoClient interface {
 	Groups(ctx context.Context) ([]string, error)
 	DescribeGroup(ctx context.Context, group string) ([]PartitionInfo, error)
 }
+
+// StaleError is returned by ConsumerGroupInfoClient.DescribeGroup() together
+// with the last known partitions of a group, when no fresh ones could be
+// fetched.
+type StaleError struct {
+	// Err is the reason no fresh partitions were fetched.
+	Err error
+	// LastSuccess is when the returned partitions were fetched.
+	LastSuccess time.Time
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("result from %s is stale: %s", e.LastSuccess.Format(time.RFC3339), e.Err)
+}
+
+// RetryableError is returned by ConsumerGroupInfoClient functions when the
+// call failed because of a transient condition, such as a consumer group
+// rebalance, and is likely to succeed if retried.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
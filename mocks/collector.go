@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"context"
+	"sync"
 
 	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
 )
@@ -10,7 +11,12 @@ var mockGroupName = "default"
 
 // ConsumerGroupsCommandClient is a fake Kafka queries which can be used for testing
 // purposes when Kafka and/or the `kafka-consumer-groups.sh` is missing.
+//
+// It is safe for concurrent use. The invocation counters must only be read
+// once all calls have returned.
 type ConsumerGroupsCommandClient struct {
+	mu sync.Mutex
+
 	GroupsFn         func() ([]string, error)
 	GroupInvocations int
 
@@ -20,14 +26,18 @@ type ConsumerGroupsCommandClient struct {
 
 // Groups returns a list of a single group.
 func (col *ConsumerGroupsCommandClient) Groups(_ context.Context) ([]string, error) {
+	col.mu.Lock()
 	col.GroupInvocations++
+	col.mu.Unlock()
 	return col.GroupsFn()
 }
 
 // DescribeGroup returns a single fake partition for the group that Groups
 // returns. For other consumer groups it returns an error.
 func (col *ConsumerGroupsCommandClient) DescribeGroup(_ context.Context, group string) ([]exporter.PartitionInfo, error) {
+	col.mu.Lock()
 	col.DescribeGroupInvocations++
+	col.mu.Unlock()
 	return col.DescribeGroupFn(group)
 }
 
@@ -2,77 +2,231 @@ package prometheus
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
 	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	partitionOffsetMetricsDesc = prometheus.NewDesc(
-		"kafka_broker_consumer_group_current_offset",
-		"Current consumed offset of a topic/partition",
-		[]string{"group_id", "consumer_address", "client_id", "topic", "partition"},
-		nil)
-	partitionLagMetricsDesc = prometheus.NewDesc(
-		"kafka_broker_consumer_group_offset_lag",
-		"Offset lag of a topic/partition",
-		[]string{"group_id", "consumer_address", "client_id", "topic", "partition"},
-		nil)
-)
-
 // PartitionInfoCollector is a Kafka prometheus.Collector. It uses a
 // exporter.ConsumerGroupInfoClient for the actual querying of Kafka.
 //
 // To speed up collection each consumer group is collected
 // concurrently.
 type PartitionInfoCollector struct {
-	groupListErrors     prometheus.Counter
-	groupDescribeErrors *prometheus.CounterVec
+	metricPrefix      string
+	legacyMetricNames bool
+	// metrics holds a metricSet per exported name prefix.
+	metrics []*metricSet
 
 	client exporter.ConsumerGroupInfoClient
 
 	ctx                  context.Context
 	execTimeout          time.Duration
 	maxConcurrentQueries int
+
+	// staleGracePeriod is how long the last known values of a group are
+	// exported after it could no longer be described. Zero disables it.
+	staleGracePeriod time.Duration
+	groups           groupStates
+
+	// trends holds the recent samples of each partition. Nil if lag trends
+	// are disabled.
+	trends *lagTrends
+
+	timestampMode           TimestampMode
+	describeCompletionGauge bool
+
+	// openMetrics enables metric families which are mostly useful with
+	// OpenMetrics exposition.
+	openMetrics       bool
+	describeDurations describeDurations
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// TimestampMode decides which timestamps are attached to exported samples.
+type TimestampMode int
+
+const (
+	// TimestampPerSample attaches the time each sample was sent to it.
+	TimestampPerSample TimestampMode = iota
+	// TimestampPerGroup attaches the time the describe of a consumer group
+	// completed to all samples of the group.
+	TimestampPerGroup
+	// TimestampNone attaches no timestamps, leaving it to Prometheus to use
+	// the time of the scrape.
+	TimestampNone
+)
+
+// ParseTimestampMode parses the name of a TimestampMode: "sample", "group" or
+// "none".
+func ParseTimestampMode(name string) (TimestampMode, error) {
+	switch name {
+	case "sample":
+		return TimestampPerSample, nil
+	case "group":
+		return TimestampPerGroup, nil
+	case "none":
+		return TimestampNone, nil
+	}
+	return 0, fmt.Errorf("unknown timestamp mode '%s'", name)
+}
+
+// CollectorOption configures optional behaviour of a PartitionInfoCollector.
+type CollectorOption func(*PartitionInfoCollector)
+
+// WithStaleSeriesGracePeriod makes the collector keep exporting the last known
+// values of a consumer group for gracePeriod after it last was described
+// successfully, also when it fails to be described or disappears from the
+// list of groups. It also makes the collector export when each group was last
+// described successfully, and which groups have disappeared.
+func WithStaleSeriesGracePeriod(gracePeriod time.Duration) CollectorOption {
+	return func(p *PartitionInfoCollector) {
+		p.staleGracePeriod = gracePeriod
+	}
+}
+
+// WithLagTrendWindow makes the collector keep the samples of each partition
+// from the last window, and export the rate of change of the lag, the
+// consumption rate, and an estimated time until the lag is zero.
+func WithLagTrendWindow(window time.Duration) CollectorOption {
+	return func(p *PartitionInfoCollector) {
+		if window > 0 {
+			p.trends = newLagTrends(window)
+		}
+	}
+}
+
+// WithTimestampMode sets which timestamps are attached to exported samples. It
+// defaults to TimestampPerSample.
+func WithTimestampMode(mode TimestampMode) CollectorOption {
+	return func(p *PartitionInfoCollector) {
+		p.timestampMode = mode
+	}
+}
+
+// WithDescribeCompletionGauge makes the collector export the time the describe
+// of each consumer group completed as a separate gauge. This is mostly useful
+// together with TimestampNone.
+func WithDescribeCompletionGauge() CollectorOption {
+	return func(p *PartitionInfoCollector) {
+		p.describeCompletionGauge = true
+	}
+}
+
+// WithOpenMetricsFamilies makes the collector export partition ownership and
+// consumer group state as info and stateset metric families, and the time
+// spent describing each consumer group as a counter carrying the duration of
+// the last describe as exemplar. Exemplars are only exposed when the
+// OpenMetrics format is negotiated.
+//
+// The client library has no native info and stateset types. They are only
+// exposed as such by an OpenMetricsHandler given OpenMetricsTypes(), and as
+// gauges otherwise.
+func WithOpenMetricsFamilies() CollectorOption {
+	return func(p *PartitionInfoCollector) {
+		p.openMetrics = true
+	}
+}
+
+// WithMetricPrefix sets the prefix of the names of all exported metrics. It
+// defaults to LegacyMetricPrefix.
+func WithMetricPrefix(prefix string) CollectorOption {
+	return func(p *PartitionInfoCollector) {
+		p.metricPrefix = prefix
+	}
+}
+
+// WithLegacyMetricNames makes the collector export all metrics under
+// LegacyMetricPrefix too, in addition to the prefix set by WithMetricPrefix.
+// This is meant to ease migration to a new prefix.
+func WithLegacyMetricNames() CollectorOption {
+	return func(p *PartitionInfoCollector) {
+		p.legacyMetricNames = true
+	}
 }
 
 // NewPartitionInfoCollector returns a prometheus.Collector that queries Kafka
 // using client. concurrency sets an upper limit on the number concurrent Kafka
 // concumer group queries running.
-func NewPartitionInfoCollector(ctx context.Context, client exporter.ConsumerGroupInfoClient, execTimeout time.Duration, maxConcurrentQueries int) *PartitionInfoCollector {
+func NewPartitionInfoCollector(ctx context.Context, client exporter.ConsumerGroupInfoClient, execTimeout time.Duration, maxConcurrentQueries int, opts ...CollectorOption) (*PartitionInfoCollector, error) {
 	if maxConcurrentQueries <= 0 {
-		log.Fatal("maxConcurrentQueries must be positive.")
+		return nil, errors.New("maxConcurrentQueries must be positive")
 	}
-	return &PartitionInfoCollector{
-		prometheus.NewCounter(prometheus.CounterOpts{
-			Name: "kafka_broker_consumer_group_list_errors",
-			Help: "Number of Kafka scraping errors.",
-		}),
-		prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name: "kafka_broker_consumer_group_describe_errors",
-			Help: "Number of Kafka scraping errors.",
-		}, []string{"group"}),
-		client,
-		ctx,
-		execTimeout,
-		maxConcurrentQueries,
+	p := &PartitionInfoCollector{
+		metricPrefix:         LegacyMetricPrefix,
+		client:               client,
+		ctx:                  ctx,
+		execTimeout:          execTimeout,
+		maxConcurrentQueries: maxConcurrentQueries,
+		groups:               groupStates{states: make(map[string]*groupState)},
+		describeDurations:    describeDurations{totals: make(map[string]float64)},
+		now:                  time.Now,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if !model.IsValidMetricName(model.LabelValue(p.metricPrefix)) {
+		return nil, fmt.Errorf("invalid metric prefix %q", p.metricPrefix)
 	}
-}
 
-// Describe transmits all metric descriptions to c.
-func (p *PartitionInfoCollector) Describe(c chan<- *prometheus.Desc) {
-	c <- partitionOffsetMetricsDesc
-	c <- partitionLagMetricsDesc
-	p.groupListErrors.Describe(c)
-	p.groupDescribeErrors.Describe(c)
+	p.metrics = []*metricSet{newMetricSet(p.metricPrefix)}
+	if p.legacyMetricNames && p.metricPrefix != LegacyMetricPrefix {
+		p.metrics = append(p.metrics, newMetricSet(LegacyMetricPrefix))
+	}
+	return p, nil
 }
 
-func (p *PartitionInfoCollector) collectGroupsFromChan(<-chan string) {
+// OpenMetricsTypes returns the OpenMetrics types of the exported metric
+// families which the client library exposes as gauges, by name. It is empty
+// unless WithOpenMetricsFamilies is used.
+func (p *PartitionInfoCollector) OpenMetricsTypes() map[string]OpenMetricsType {
+	types := make(map[string]OpenMetricsType)
+	if !p.openMetrics {
+		return types
+	}
+	for _, m := range p.metrics {
+		for name, metricType := range m.openMetricsTypes {
+			types[name] = metricType
+		}
+	}
+	return types
+}
 
+// Describe transmits all metric descriptions to c.
+func (p *PartitionInfoCollector) Describe(c chan<- *prometheus.Desc) {
+	for _, m := range p.metrics {
+		c <- m.currentOffset
+		c <- m.offsetLag
+		if p.staleGracePeriod > 0 {
+			c <- m.lastSuccess
+			c <- m.disappeared
+		}
+		if p.describeCompletionGauge {
+			c <- m.describeCompletion
+		}
+		if p.openMetrics {
+			c <- m.partitionOwnerInfo
+			c <- m.partitionAssignment
+			c <- m.groupState
+			c <- m.describeDuration
+		}
+		if p.trends != nil {
+			c <- m.lagVelocity
+			c <- m.consumptionRate
+			c <- m.timeToZeroLag
+		}
+		m.listErrors.Describe(c)
+		m.describeErrors.Describe(c)
+	}
 }
 
 // Collect triggers an on-demand scraping from Kafka and transmits metrics into
@@ -80,17 +234,38 @@ func (p *PartitionInfoCollector) collectGroupsFromChan(<-chan string) {
 func (p *PartitionInfoCollector) Collect(c chan<- prometheus.Metric) {
 	// Important that these are collected _after_ the Kafka collection below to
 	// correctly accommodate for the errors that happened during the scrape.
-	defer p.groupDescribeErrors.Collect(c)
-	defer p.groupListErrors.Collect(c)
+	defer p.collectErrors(c)
+
+	// Groups whose partitions have been exported by this scrape.
+	exported := make(map[string]bool)
+	var exportedMu sync.Mutex
+	if p.staleGracePeriod > 0 {
+		// Deferred after the error counters, so runs before them but after
+		// all groups have been collected.
+		defer func() {
+			p.collectRemembered(c, exported)
+		}()
+	}
 
 	ctx, cancel := context.WithTimeout(p.ctx, p.execTimeout)
 	groupnames, err := p.client.Groups(ctx)
 	cancel()
 	if err != nil {
-		log.Error("Could not list groups:", err)
-		p.groupListErrors.Inc()
+		log.WithError(err).Error("Could not list groups")
+		for _, m := range p.metrics {
+			m.listErrors.Inc()
+		}
 		return
 	}
+	if p.staleGracePeriod > 0 {
+		p.groups.listed(groupnames, p.now())
+	}
+	if p.trends != nil {
+		p.trends.expire(p.now())
+	}
+	if p.openMetrics {
+		p.describeDurations.listed(groupnames)
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(p.maxConcurrentQueries)
@@ -98,19 +273,10 @@ func (p *PartitionInfoCollector) Collect(c chan<- prometheus.Metric) {
 	collectGroupWorker := func() {
 		defer wg.Done()
 		for groupname := range groupsToProcess {
-			ctx, cancel := context.WithTimeout(p.ctx, p.execTimeout)
-			partitions, err := p.client.DescribeGroup(ctx, groupname)
-			cancel()
-			if err != nil {
-				log.Errorf("Could not describe group '%s': %s", groupname, err)
-				p.groupDescribeErrors.WithLabelValues(groupname).Inc()
-				continue
-			}
-
-			for _, part := range partitions {
-				labels := []string{groupname, part.ConsumerAddress, part.ClientID, part.Topic, part.PartitionID}
-				sendGaugeOrLog(c, partitionOffsetMetricsDesc, part.CurrentOffset, labels...)
-				sendGaugeOrLog(c, partitionLagMetricsDesc, part.Lag, labels...)
+			if p.collectGroup(c, groupname) {
+				exportedMu.Lock()
+				exported[groupname] = true
+				exportedMu.Unlock()
 			}
 		}
 	}
@@ -124,13 +290,140 @@ func (p *PartitionInfoCollector) Collect(c chan<- prometheus.Metric) {
 	wg.Wait()
 }
 
-func sendGaugeOrLog(c chan<- prometheus.Metric, desc *prometheus.Desc, value int64, labelValues ...string) {
-	metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, float64(value), labelValues...)
+// collectGroup describes a group and exports its partitions. It returns
+// whether anything was exported.
+func (p *PartitionInfoCollector) collectGroup(c chan<- prometheus.Metric, groupname string) bool {
+	ctx, cancel := context.WithTimeout(p.ctx, p.execTimeout)
+	start := p.now()
+	partitions, err := p.client.DescribeGroup(ctx, groupname)
+	cancel()
+	describedAt := p.now()
+	if p.openMetrics {
+		p.sendDescribeDuration(c, groupname, partitions, start, describedAt)
+	}
+	if stale, ok := err.(*exporter.StaleError); ok && describedAt.Sub(stale.LastSuccess) < p.staleGracePeriod {
+		// The client handed us the last known partitions of the group. They
+		// are still better than nothing, and are marked stale by their last
+		// success timestamp. Without a grace period they are dropped like
+		// any other failure.
+		log.WithField("group", groupname).WithError(stale).Warn("Could not describe group, exporting stale result")
+		p.incDescribeErrors(groupname)
+		p.groups.described(groupname, partitions, stale.LastSuccess)
+	} else if err != nil {
+		log.WithField("group", groupname).WithError(err).Error("Could not describe group")
+		p.incDescribeErrors(groupname)
+		// Any last known values are exported by collectRemembered.
+		return false
+	} else {
+		if p.staleGracePeriod > 0 {
+			p.groups.described(groupname, partitions, describedAt)
+		}
+		if p.trends != nil {
+			p.sendTrends(c, groupname, partitions, describedAt)
+		}
+	}
+
+	p.sendPartitions(c, groupname, partitions, describedAt)
+	if p.openMetrics {
+		p.sendOwnership(c, groupname, partitions, describedAt)
+	}
+	if p.describeCompletionGauge {
+		for _, m := range p.metrics {
+			p.sendFloatGaugeOrLog(c, describedAt, m.describeCompletion, float64(describedAt.UnixNano())/1e9, groupname)
+		}
+	}
+	return true
+}
+
+// collectRemembered exports the last known values of groups that were not
+// exported by this scrape, as long as they are within the grace period. It
+// also exports when each group was last described successfully, and which
+// groups have disappeared.
+func (p *PartitionInfoCollector) collectRemembered(c chan<- prometheus.Metric, exported map[string]bool) {
+	now := p.now()
+	for groupname, state := range p.groups.expire(now, p.staleGracePeriod) {
+		if !exported[groupname] {
+			p.sendPartitions(c, groupname, state.partitions, now)
+		}
+		for _, m := range p.metrics {
+			p.sendGaugeOrLog(c, now, m.lastSuccess, state.lastSuccess.Unix(), groupname)
+			if !state.disappeared.IsZero() {
+				p.sendGaugeOrLog(c, now, m.disappeared, 1, groupname)
+			}
+		}
+	}
+}
+
+func (p *PartitionInfoCollector) sendPartitions(c chan<- prometheus.Metric, groupname string, partitions []exporter.PartitionInfo, describedAt time.Time) {
+	for _, part := range partitions {
+		labels := []string{groupname, part.ConsumerAddress, part.ClientID, part.Topic, part.PartitionID}
+		for _, m := range p.metrics {
+			p.sendGaugeOrLog(c, describedAt, m.currentOffset, part.CurrentOffset, labels...)
+			p.sendGaugeOrLog(c, describedAt, m.offsetLag, part.Lag, labels...)
+		}
+	}
+}
+
+func (p *PartitionInfoCollector) incDescribeErrors(groupname string) {
+	for _, m := range p.metrics {
+		m.describeErrors.WithLabelValues(groupname).Inc()
+	}
+}
+
+// collectErrors transmits the error counters into c.
+func (p *PartitionInfoCollector) collectErrors(c chan<- prometheus.Metric) {
+	for _, m := range p.metrics {
+		m.listErrors.Collect(c)
+		m.describeErrors.Collect(c)
+	}
+}
+
+// sendTrends records a sample of each partition, and exports the resulting
+// trends. Samples are taken at the time the partitions were fetched from
+// Kafka, or at describedAt if that is unknown, so that results served from a
+// cache do not add the same sample again at a later time.
+func (p *PartitionInfoCollector) sendTrends(c chan<- prometheus.Metric, groupname string, partitions []exporter.PartitionInfo, describedAt time.Time) {
+	for _, part := range partitions {
+		fetchedAt := part.FetchedAt
+		if fetchedAt.IsZero() {
+			fetchedAt = describedAt
+		}
+		window := p.trends.add(partitionKey{groupname, part.Topic, part.PartitionID}, lagSample{fetchedAt, part.Lag, part.CurrentOffset})
+		labels := []string{groupname, part.Topic, part.PartitionID}
+		velocity, velocityOk := window.lagVelocity()
+		rate, rateOk := window.consumptionRate()
+		ttz, ttzOk := window.timeToZeroLag()
+		for _, m := range p.metrics {
+			if velocityOk {
+				p.sendFloatGaugeOrLog(c, describedAt, m.lagVelocity, velocity, labels...)
+			}
+			if rateOk {
+				p.sendFloatGaugeOrLog(c, describedAt, m.consumptionRate, rate, labels...)
+			}
+			if ttzOk {
+				p.sendFloatGaugeOrLog(c, describedAt, m.timeToZeroLag, ttz, labels...)
+			}
+		}
+	}
+}
+
+// sendGaugeOrLog transmits a gauge into c. describedAt is when the value was
+// described, and is used as timestamp depending on p.timestampMode.
+func (p *PartitionInfoCollector) sendGaugeOrLog(c chan<- prometheus.Metric, describedAt time.Time, desc *prometheus.Desc, value int64, labelValues ...string) {
+	p.sendFloatGaugeOrLog(c, describedAt, desc, float64(value), labelValues...)
+}
+
+func (p *PartitionInfoCollector) sendFloatGaugeOrLog(c chan<- prometheus.Metric, describedAt time.Time, desc *prometheus.Desc, value float64, labelValues ...string) {
+	metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
 	if err != nil {
-		log.Warn("Could not construct a metric:", err)
+		log.WithError(err).Warn("Could not construct a metric")
 		return
 	}
+	p.send(c, describedAt, metric)
+}
 
+// send transmits metric into c, timestamped according to p.timestampMode.
+func (p *PartitionInfoCollector) send(c chan<- prometheus.Metric, describedAt time.Time, metric prometheus.Metric) {
 	// Generally bundling timestamps with Prometheus metrics shouldn't be
 	// necessary. However, in Kafka's case it can vary quite a lot between
 	// consumer groups how long it can take to scrape the statisic (duration is
@@ -138,7 +431,17 @@ func sendGaugeOrLog(c chan<- prometheus.Metric, desc *prometheus.Desc, value int
 	// probably more). In case it takes ~1 min for one group to be scraped,
 	// while it take 2 seconds for another, we want to give a more realistic
 	// timestamp to the Prometheus scraper.
-	c <- newTimestampedMetricNow(metric)
+	//
+	// Explicit timestamps do not play well with remote write and federation
+	// though, which is why they can be turned off.
+	switch p.timestampMode {
+	case TimestampPerSample:
+		c <- newTimestampedMetricNow(metric)
+	case TimestampPerGroup:
+		c <- newTimestampedMetric(metric, describedAt)
+	default:
+		c <- metric
+	}
 }
 
 // timestampedMetric wraps a Metric and makes sure to add timestamp to it. All
@@ -152,9 +455,13 @@ type timestampedMetric struct {
 var nanosPerMillis = int64(time.Millisecond / time.Nanosecond)
 
 func newTimestampedMetricNow(delegate prometheus.Metric) *timestampedMetric {
+	return newTimestampedMetric(delegate, time.Now())
+}
+
+func newTimestampedMetric(delegate prometheus.Metric, t time.Time) *timestampedMetric {
 	return &timestampedMetric{
 		delegate,
-		time.Now().UnixNano() / nanosPerMillis,
+		t.UnixNano() / nanosPerMillis,
 	}
 }
 
@@ -2,23 +2,36 @@ package prometheus
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
 	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 )
 
+// newTestCollector returns a collector like NewPartitionInfoCollector, failing
+// the test if it cannot be created.
+func newTestCollector(t *testing.T, ctx context.Context, client exporter.ConsumerGroupInfoClient, opts ...CollectorOption) *PartitionInfoCollector {
+	collector, err := NewPartitionInfoCollector(ctx, client, time.Minute, 4, opts...)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return collector
+}
+
 func TestPartitionInfoCollector(t *testing.T) {
 	registry := prometheus.NewRegistry()
 
 	timeout := 1 * time.Minute
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	collector := NewPartitionInfoCollector(ctx, mocks.NewBasicConsumerGroupsCommandClient(), timeout, 4)
+	collector := newTestCollector(t, ctx, mocks.NewBasicConsumerGroupsCommandClient())
 	cancel()
 	registry.MustRegister(collector)
 
@@ -45,3 +58,394 @@ func TestPartitionInfoCollector(t *testing.T) {
 		t.Error("Unexpected body with", nlines, "lines:", s)
 	}
 }
+
+// gatherValues gathers all metrics from registry and returns their values by
+// metric name. If there are multiple metrics with the same name, the last one
+// wins.
+func gatherValues(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	found := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			if metric.Gauge != nil {
+				found[family.GetName()] = metric.Gauge.GetValue()
+			} else if metric.Counter != nil {
+				found[family.GetName()] = metric.Counter.GetValue()
+			}
+		}
+	}
+	return found
+}
+
+func TestPartitionInfoCollectorExportsStaleResults(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	describe := client.DescribeGroupFn
+	client.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		partitions, _ := describe(group)
+		return partitions, &exporter.StaleError{Err: errors.New("circuit breaker is open"), LastSuccess: time.Unix(900, 0)}
+	}
+
+	collector := newTestCollector(t, context.Background(), client, WithStaleSeriesGracePeriod(5*time.Minute))
+	collector.now = func() time.Time { return time.Unix(1000, 0) }
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	found := gatherValues(t, registry)
+	if found["kafka_broker_consumer_group_offset_lag"] != 99 {
+		t.Error("Expected the stale lag to be exported. Found:", found)
+	}
+	if found["kafka_broker_consumer_group_last_success_timestamp_seconds"] != 900 {
+		t.Error("Expected the stale result to be marked by its last success. Found:", found)
+	}
+	if found["kafka_broker_consumer_group_describe_errors"] != 1 {
+		t.Error("Expected the stale result to count as a describe error. Found:", found)
+	}
+}
+
+func TestPartitionInfoCollectorDropsStaleResultsWithoutGracePeriod(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	describe := client.DescribeGroupFn
+	client.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		partitions, _ := describe(group)
+		return partitions, &exporter.StaleError{Err: errors.New("circuit breaker is open"), LastSuccess: time.Now()}
+	}
+
+	collector := newTestCollector(t, context.Background(), client)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	found := gatherValues(t, registry)
+	if _, ok := found["kafka_broker_consumer_group_offset_lag"]; ok {
+		t.Error("Expected the stale lag to not be exported. Found:", found)
+	}
+	if found["kafka_broker_consumer_group_describe_errors"] != 1 {
+		t.Error("Expected the stale result to count as a describe error. Found:", found)
+	}
+}
+
+func TestPartitionInfoCollectorStaleSeriesGracePeriod(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	describe := client.DescribeGroupFn
+	groups := client.GroupsFn
+
+	now := time.Unix(1000, 0)
+	collector := newTestCollector(t, context.Background(), client, WithStaleSeriesGracePeriod(5*time.Minute))
+	collector.now = func() time.Time { return now }
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	found := gatherValues(t, registry)
+	if found["kafka_broker_consumer_group_last_success_timestamp_seconds"] != 1000 {
+		t.Error("Expected the last success timestamp to be exported. Found:", found)
+	}
+
+	// The group fails to be described.
+	now = now.Add(time.Minute)
+	client.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		return nil, errors.New("timed out")
+	}
+	found = gatherValues(t, registry)
+	if found["kafka_broker_consumer_group_offset_lag"] != 99 || found["kafka_broker_consumer_group_last_success_timestamp_seconds"] != 1000 {
+		t.Error("Expected the last known values to be exported. Found:", found)
+	}
+	if _, ok := found["kafka_broker_consumer_group_disappeared"]; ok {
+		t.Error("Expected the group to not be marked as disappeared. Found:", found)
+	}
+
+	// The group disappears.
+	now = now.Add(time.Minute)
+	client.DescribeGroupFn = describe
+	client.GroupsFn = func() ([]string, error) {
+		return nil, nil
+	}
+	found = gatherValues(t, registry)
+	if found["kafka_broker_consumer_group_offset_lag"] != 99 || found["kafka_broker_consumer_group_disappeared"] != 1 {
+		t.Error("Expected the group to be marked as disappeared. Found:", found)
+	}
+
+	// The grace period passes.
+	now = now.Add(5 * time.Minute)
+	found = gatherValues(t, registry)
+	for _, name := range []string{"kafka_broker_consumer_group_offset_lag", "kafka_broker_consumer_group_last_success_timestamp_seconds", "kafka_broker_consumer_group_disappeared"} {
+		if _, ok := found[name]; ok {
+			t.Error("Expected", name, "to not be exported after the grace period.")
+		}
+	}
+
+	// The group comes back.
+	client.GroupsFn = groups
+	found = gatherValues(t, registry)
+	if _, ok := found["kafka_broker_consumer_group_disappeared"]; ok || found["kafka_broker_consumer_group_offset_lag"] != 99 {
+		t.Error("Expected the group to be exported as usual. Found:", found)
+	}
+}
+
+func TestPartitionInfoCollectorLagTrends(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	lag, offset := int64(100), int64(1000)
+	client.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		return []exporter.PartitionInfo{{Topic: "topic", PartitionID: "0", Lag: lag, CurrentOffset: offset}}, nil
+	}
+
+	now := time.Unix(1000, 0)
+	collector := newTestCollector(t, context.Background(), client, WithLagTrendWindow(5*time.Minute))
+	collector.now = func() time.Time { return now }
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	found := gatherValues(t, registry)
+	if _, ok := found["kafka_broker_consumer_group_offset_lag_velocity"]; ok {
+		t.Error("Expected no lag velocity after a single scrape. Found:", found)
+	}
+
+	now = now.Add(10 * time.Second)
+	lag, offset = 80, 1200
+	found = gatherValues(t, registry)
+	if found["kafka_broker_consumer_group_offset_lag_velocity"] != -2 {
+		t.Error("Unexpected lag velocity. Found:", found)
+	}
+	if found["kafka_broker_consumer_group_consumption_rate"] != 20 {
+		t.Error("Unexpected consumption rate. Found:", found)
+	}
+	if found["kafka_broker_consumer_group_lag_time_to_zero_seconds"] != 40 {
+		t.Error("Unexpected time to zero lag. Found:", found)
+	}
+}
+
+func TestPartitionInfoCollectorLagTrendsIgnoreCachedResults(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	fetchedAt := time.Unix(1000, 0)
+	lag, offset := int64(100), int64(1000)
+	client.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		return []exporter.PartitionInfo{{Topic: "topic", PartitionID: "0", Lag: lag, CurrentOffset: offset, FetchedAt: fetchedAt}}, nil
+	}
+
+	now := fetchedAt
+	collector := newTestCollector(t, context.Background(), client, WithLagTrendWindow(5*time.Minute))
+	collector.now = func() time.Time { return now }
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	gatherValues(t, registry)
+
+	fetchedAt = fetchedAt.Add(10 * time.Second)
+	lag, offset = 80, 1200
+	now = fetchedAt
+	gatherValues(t, registry)
+
+	// The same result is served from a cache on the next scrapes.
+	for i := 0; i < 3; i++ {
+		now = now.Add(10 * time.Second)
+		found := gatherValues(t, registry)
+		if found["kafka_broker_consumer_group_offset_lag_velocity"] != -2 || found["kafka_broker_consumer_group_consumption_rate"] != 20 {
+			t.Error("Expected cached results to not change the trends. Found:", found)
+		}
+	}
+}
+
+func TestPartitionInfoCollectorMetricPrefix(t *testing.T) {
+	for _, test := range []struct {
+		opts     []CollectorOption
+		expected []string
+		absent   []string
+	}{
+		{
+			opts:     nil,
+			expected: []string{"kafka_broker_consumer_group_offset_lag"},
+		},
+		{
+			opts:     []CollectorOption{WithMetricPrefix("kafka_consumer_group")},
+			expected: []string{"kafka_consumer_group_offset_lag", "kafka_consumer_group_current_offset"},
+			absent:   []string{"kafka_broker_consumer_group_offset_lag"},
+		},
+		{
+			opts:     []CollectorOption{WithMetricPrefix("kafka_consumer_group"), WithLegacyMetricNames()},
+			expected: []string{"kafka_consumer_group_offset_lag", "kafka_broker_consumer_group_offset_lag"},
+		},
+	} {
+		collector := newTestCollector(t, context.Background(), mocks.NewBasicConsumerGroupsCommandClient(), test.opts...)
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector)
+
+		found := gatherValues(t, registry)
+		for _, name := range test.expected {
+			if _, ok := found[name]; !ok {
+				t.Error("Expected", name, "to be exported. Found:", found)
+			}
+		}
+		for _, name := range test.absent {
+			if _, ok := found[name]; ok {
+				t.Error("Expected", name, "to not be exported. Found:", found)
+			}
+		}
+	}
+}
+
+func TestPartitionInfoCollectorTimestampModes(t *testing.T) {
+	describedAt := time.Unix(1000, 0)
+	for _, test := range []struct {
+		mode     TimestampMode
+		expected func(ts *int64) bool
+	}{
+		{TimestampPerSample, func(ts *int64) bool { return ts != nil && *ts > 1000000 }},
+		{TimestampPerGroup, func(ts *int64) bool { return ts != nil && *ts == 1000000 }},
+		{TimestampNone, func(ts *int64) bool { return ts == nil }},
+	} {
+		collector := newTestCollector(t, context.Background(), mocks.NewBasicConsumerGroupsCommandClient(),
+			WithTimestampMode(test.mode), WithDescribeCompletionGauge())
+		collector.now = func() time.Time { return describedAt }
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector)
+
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		foundCompletion := false
+		for _, family := range families {
+			if family.GetName() == "kafka_broker_consumer_group_describe_completion_timestamp_seconds" {
+				foundCompletion = family.Metric[0].Gauge.GetValue() == 1000
+			}
+			if family.GetName() != "kafka_broker_consumer_group_offset_lag" {
+				continue
+			}
+			if ts := family.Metric[0].TimestampMs; !test.expected(ts) {
+				t.Error("Unexpected timestamp for mode", test.mode, ":", ts)
+			}
+		}
+		if !foundCompletion {
+			t.Error("Expected the describe completion time to be exported for mode", test.mode)
+		}
+	}
+}
+
+func TestPartitionInfoCollectorOpenMetricsFamilies(t *testing.T) {
+	collector := newTestCollector(t, context.Background(), mocks.NewBasicConsumerGroupsCommandClient(),
+		WithOpenMetricsFamilies(), WithTimestampMode(TimestampNone))
+	describedAt := time.Unix(1000, 0)
+	collector.now = func() time.Time {
+		describedAt = describedAt.Add(time.Second)
+		return describedAt
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	handler := NewOpenMetricsHandler(registry, collector.OpenMetricsTypes(),
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	req := httptest.NewRequest("GET", "http://localhost/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	body, _ := ioutil.ReadAll(w.Result().Body)
+
+	for _, expected := range []string{
+		`# TYPE kafka_broker_consumer_group_partition_owner info`,
+		`# HELP kafka_broker_consumer_group_partition_owner Consumer owning a topic/partition. Always 1`,
+		`kafka_broker_consumer_group_partition_owner_info{client_id="consumer-99",consumer_address="127.0.0.1",group_id="default",partition="0",topic="testtopic"} 1.0`,
+		`# TYPE kafka_broker_consumer_group_partition_assignment stateset`,
+		`kafka_broker_consumer_group_partition_assignment{group_id="default",kafka_broker_consumer_group_partition_assignment="assigned",partition="0",topic="testtopic"} 1.0`,
+		`kafka_broker_consumer_group_partition_assignment{group_id="default",kafka_broker_consumer_group_partition_assignment="unassigned",partition="0",topic="testtopic"} 0.0`,
+		`# TYPE kafka_broker_consumer_group_group_state stateset`,
+		`kafka_broker_consumer_group_group_state{group_id="default",kafka_broker_consumer_group_group_state="active"} 1.0`,
+		`# TYPE kafka_broker_consumer_group_describe_duration_seconds counter`,
+		`kafka_broker_consumer_group_describe_duration_seconds_total{group_id="default"} 1.0 # {group_id="default"} 1.0 1002.0`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Error("Expected line", expected, "in:", string(body))
+		}
+	}
+	if !strings.HasSuffix(string(body), "# EOF\n") {
+		t.Error("Expected the exposition to be terminated:", string(body))
+	}
+
+	// The text format has no info and stateset types.
+	req = httptest.NewRequest("GET", "http://localhost/metrics", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	body, _ = ioutil.ReadAll(w.Result().Body)
+	if !strings.Contains(string(body), "# TYPE kafka_broker_consumer_group_partition_owner_info gauge") {
+		t.Error("Expected a gauge in the text format:", string(body))
+	}
+}
+
+// gatherDescribeDurations returns the describe duration counters of each group
+// gathered from registry.
+func gatherDescribeDurations(t *testing.T, registry *prometheus.Registry) map[string]*dto.Counter {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	found := make(map[string]*dto.Counter)
+	for _, family := range families {
+		if family.GetName() != "kafka_broker_consumer_group_describe_duration_seconds_total" {
+			continue
+		}
+		for _, metric := range family.Metric {
+			found[metric.Label[0].GetValue()] = metric.Counter
+		}
+	}
+	return found
+}
+
+func TestPartitionInfoCollectorDescribeDurationOfCachedResults(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	fetchedAt := time.Unix(1000, 0)
+	client.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		return []exporter.PartitionInfo{{Topic: "topic", PartitionID: "0", FetchedAt: fetchedAt}}, nil
+	}
+	collector := newTestCollector(t, context.Background(), client, WithOpenMetricsFamilies())
+	now := fetchedAt.Add(-time.Second)
+	collector.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	counter := gatherDescribeDurations(t, registry)["default"]
+	if counter.GetValue() != 1 || counter.Exemplar.GetValue() != 1 {
+		t.Fatal("Expected the describe to take 1s. Was:", counter)
+	}
+
+	// The same result is served from a cache on the next scrape.
+	counter = gatherDescribeDurations(t, registry)["default"]
+	if counter.GetValue() != 1 {
+		t.Error("Expected cached results to not add to the total. Was:", counter.GetValue())
+	}
+	if counter.Exemplar != nil {
+		t.Error("Expected no exemplar for cached results. Was:", counter.Exemplar)
+	}
+}
+
+func TestPartitionInfoCollectorForgetsDescribeDurationsOfDisappearedGroups(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	collector := newTestCollector(t, context.Background(), client, WithOpenMetricsFamilies())
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	gatherValues(t, registry)
+
+	client.GroupsFn = func() ([]string, error) {
+		return []string{"other"}, nil
+	}
+	found := gatherDescribeDurations(t, registry)
+	if _, ok := found["default"]; ok {
+		t.Error("Expected the disappeared group to not be exported. Found:", found)
+	}
+	collector.describeDurations.mu.Lock()
+	defer collector.describeDurations.mu.Unlock()
+	if _, ok := collector.describeDurations.totals["default"]; ok {
+		t.Error("Expected the total of the disappeared group to be forgotten.")
+	}
+}
+
+func TestNewPartitionInfoCollectorValidates(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	if _, err := NewPartitionInfoCollector(context.Background(), client, time.Minute, 0); err == nil {
+		t.Error("Expected no concurrent queries to be rejected.")
+	}
+	if _, err := NewPartitionInfoCollector(context.Background(), client, time.Minute, 4, WithMetricPrefix("kafka-lag")); err == nil {
+		t.Error("Expected an invalid metric prefix to be rejected.")
+	}
+}
@@ -0,0 +1,111 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LegacyMetricPrefix is the prefix metric names have had historically. It is
+// the default, so as not to break existing dashboards and alerts.
+const LegacyMetricPrefix = "kafka_broker_consumer_group"
+
+// metricSet holds the descriptions and counters of all metrics exported by a
+// PartitionInfoCollector under a single name prefix.
+type metricSet struct {
+	currentOffset      *prometheus.Desc
+	offsetLag          *prometheus.Desc
+	lastSuccess        *prometheus.Desc
+	disappeared        *prometheus.Desc
+	describeCompletion *prometheus.Desc
+	lagVelocity        *prometheus.Desc
+	consumptionRate    *prometheus.Desc
+	timeToZeroLag      *prometheus.Desc
+
+	partitionOwnerInfo  *prometheus.Desc
+	partitionAssignment *prometheus.Desc
+	groupState          *prometheus.Desc
+	describeDuration    *prometheus.Desc
+	// openMetricsTypes holds the OpenMetrics types of the families above
+	// that are exposed as gauges by the client library.
+	openMetricsTypes map[string]OpenMetricsType
+
+	listErrors     prometheus.Counter
+	describeErrors *prometheus.CounterVec
+}
+
+func newMetricSet(prefix string) *metricSet {
+	return &metricSet{
+		currentOffset: prometheus.NewDesc(
+			prefix+"_current_offset",
+			"Current consumed offset of a topic/partition",
+			[]string{"group_id", "consumer_address", "client_id", "topic", "partition"},
+			nil),
+		offsetLag: prometheus.NewDesc(
+			prefix+"_offset_lag",
+			"Offset lag of a topic/partition",
+			[]string{"group_id", "consumer_address", "client_id", "topic", "partition"},
+			nil),
+		lastSuccess: prometheus.NewDesc(
+			prefix+"_last_success_timestamp_seconds",
+			"Unix time at which the exported values of a consumer group were last described successfully",
+			[]string{"group_id"},
+			nil),
+		disappeared: prometheus.NewDesc(
+			prefix+"_disappeared",
+			"Consumer groups which were listed before, but are no longer",
+			[]string{"group_id"},
+			nil),
+		describeCompletion: prometheus.NewDesc(
+			prefix+"_describe_completion_timestamp_seconds",
+			"Unix time at which the last describe of a consumer group completed",
+			[]string{"group_id"},
+			nil),
+		lagVelocity: prometheus.NewDesc(
+			prefix+"_offset_lag_velocity",
+			"Change of the offset lag of a topic/partition per second, over the lag trend window",
+			[]string{"group_id", "topic", "partition"},
+			nil),
+		consumptionRate: prometheus.NewDesc(
+			prefix+"_consumption_rate",
+			"Offsets of a topic/partition consumed per second, over the lag trend window",
+			[]string{"group_id", "topic", "partition"},
+			nil),
+		timeToZeroLag: prometheus.NewDesc(
+			prefix+"_lag_time_to_zero_seconds",
+			"Estimated time until the offset lag of a topic/partition is zero, given its current lag velocity. Not exported while the lag is not decreasing",
+			[]string{"group_id", "topic", "partition"},
+			nil),
+		partitionOwnerInfo: prometheus.NewDesc(
+			prefix+"_partition_owner_info",
+			"Consumer owning a topic/partition. Always 1",
+			[]string{"group_id", "topic", "partition", "client_id", "consumer_address"},
+			nil),
+		partitionAssignment: prometheus.NewDesc(
+			prefix+"_partition_assignment",
+			"Whether a topic/partition is assigned to a consumer. A stateset",
+			[]string{"group_id", "topic", "partition", prefix + "_partition_assignment"},
+			nil),
+		groupState: prometheus.NewDesc(
+			prefix+"_group_state",
+			"Whether a consumer group has any active consumers. A stateset",
+			[]string{"group_id", prefix + "_group_state"},
+			nil),
+		describeDuration: prometheus.NewDesc(
+			prefix+"_describe_duration_seconds_total",
+			"Total time spent describing a consumer group. The exemplar holds the duration of the last describe",
+			[]string{"group_id"},
+			nil),
+		openMetricsTypes: map[string]OpenMetricsType{
+			prefix + "_partition_owner_info": OpenMetricsInfo,
+			prefix + "_partition_assignment": OpenMetricsStateSet,
+			prefix + "_group_state":          OpenMetricsStateSet,
+		},
+		listErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: prefix + "_list_errors",
+			Help: "Number of Kafka scraping errors.",
+		}),
+		describeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_describe_errors",
+			Help: "Number of Kafka scraping errors.",
+		}, []string{"group"}),
+	}
+}
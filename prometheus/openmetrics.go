@@ -0,0 +1,244 @@
+package prometheus
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// OpenMetricsType is an OpenMetrics metric type which the client library
+// cannot expose natively.
+type OpenMetricsType string
+
+const (
+	// OpenMetricsInfo is the type of metric families whose samples are named
+	// after the family with an "_info" suffix, have the value 1, and carry
+	// information in their labels.
+	OpenMetricsInfo OpenMetricsType = "info"
+	// OpenMetricsStateSet is the type of metric families with a label named
+	// after the family holding a state, whose samples are 1 for the current
+	// state and 0 for all others.
+	OpenMetricsStateSet OpenMetricsType = "stateset"
+)
+
+// OpenMetricsHandler serves metrics like the handler of promhttp, except that
+// when the OpenMetrics format is negotiated, the metric families it knows to
+// be info or stateset families are exposed with those types rather than as
+// gauges. Other formats have no such types, so they keep exposing gauges.
+type OpenMetricsHandler struct {
+	gatherer prometheus.Gatherer
+	types    map[string]OpenMetricsType
+	fallback http.Handler
+}
+
+// NewOpenMetricsHandler returns an OpenMetricsHandler serving the metrics of
+// gatherer. types holds the OpenMetrics type of metric families by name, and
+// fallback serves all formats other than OpenMetrics.
+func NewOpenMetricsHandler(gatherer prometheus.Gatherer, types map[string]OpenMetricsType, fallback http.Handler) *OpenMetricsHandler {
+	return &OpenMetricsHandler{gatherer, types, fallback}
+}
+
+func (h *OpenMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if expfmt.NegotiateIncludingOpenMetrics(r.Header) != expfmt.FmtOpenMetrics {
+		h.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	families, err := h.gatherer.Gather()
+	if err != nil {
+		log.WithError(err).Error("Could not gather metrics")
+		http.Error(w, "could not gather metrics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if err := writeOpenMetricsFamily(&buf, family, h.types[family.GetName()]); err != nil {
+			log.WithError(err).Error("Could not encode metrics")
+			http.Error(w, "could not encode metrics: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	expfmt.FinalizeOpenMetrics(&buf)
+	w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+	w.Write(buf.Bytes())
+}
+
+// writeOpenMetricsFamily writes family in the OpenMetrics format, typed as
+// metricType if it is set. The client library encodes info and stateset
+// families as gauges, so their metadata lines are rewritten.
+func writeOpenMetricsFamily(buf *bytes.Buffer, family *dto.MetricFamily, metricType OpenMetricsType) error {
+	if metricType == "" {
+		_, err := expfmt.MetricFamilyToOpenMetrics(buf, family)
+		return err
+	}
+
+	var encoded bytes.Buffer
+	if _, err := expfmt.MetricFamilyToOpenMetrics(&encoded, family); err != nil {
+		return err
+	}
+	name := family.GetName()
+	familyName := name
+	if metricType == OpenMetricsInfo {
+		familyName = strings.TrimSuffix(name, "_info")
+	}
+	for _, line := range strings.SplitAfter(encoded.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# HELP "+name+" "):
+			line = "# HELP " + familyName + strings.TrimPrefix(line, "# HELP "+name)
+		case line == "# TYPE "+name+" gauge\n":
+			line = "# TYPE " + familyName + " " + string(metricType) + "\n"
+		}
+		buf.WriteString(line)
+	}
+	return nil
+}
+
+// describeDurations accumulates the time spent describing each consumer group.
+type describeDurations struct {
+	mu     sync.Mutex
+	totals map[string]float64
+}
+
+// add adds d to the total of group and returns the new total in seconds.
+func (d *describeDurations) add(group string, duration time.Duration) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.totals[group] += duration.Seconds()
+	return d.totals[group]
+}
+
+// listed forgets the totals of all groups not in groups, so that groups that
+// have disappeared are not remembered forever.
+func (d *describeDurations) listed(groups []string) {
+	exists := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		exists[group] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for group := range d.totals {
+		if !exists[group] {
+			delete(d.totals, group)
+		}
+	}
+}
+
+// sendDescribeDuration accounts for a describe of groupname between start and
+// end, and exports the total duration with the last one as exemplar. Results
+// fetched from Kafka before start were served from a cache and took no time
+// describing the group, so they neither add to the total nor get an exemplar.
+func (p *PartitionInfoCollector) sendDescribeDuration(c chan<- prometheus.Metric, groupname string, partitions []exporter.PartitionInfo, start, end time.Time) {
+	duration := end.Sub(start)
+	cached := fetchedBefore(partitions, start)
+	if cached {
+		duration = 0
+	}
+	total := p.describeDurations.add(groupname, duration)
+	for _, m := range p.metrics {
+		metric, err := prometheus.NewConstMetric(m.describeDuration, prometheus.CounterValue, total, groupname)
+		if err != nil {
+			log.WithField("group", groupname).WithError(err).Warn("Could not construct a metric")
+			continue
+		}
+		if !cached {
+			if exemplar, err := newExemplarMetric(metric, duration.Seconds(), end, "group_id", groupname); err != nil {
+				log.WithField("group", groupname).WithError(err).Warn("Could not construct an exemplar")
+			} else {
+				metric = exemplar
+			}
+		}
+		p.send(c, end, metric)
+	}
+}
+
+// fetchedBefore returns whether partitions were fetched from Kafka before t.
+// False if unknown.
+func fetchedBefore(partitions []exporter.PartitionInfo, t time.Time) bool {
+	for _, part := range partitions {
+		if part.FetchedAt.IsZero() || !part.FetchedAt.Before(t) {
+			return false
+		}
+	}
+	return len(partitions) > 0
+}
+
+// sendOwnership exports which consumer owns each partition, whether each
+// partition is assigned at all, and whether the group has any consumer.
+func (p *PartitionInfoCollector) sendOwnership(c chan<- prometheus.Metric, groupname string, partitions []exporter.PartitionInfo, describedAt time.Time) {
+	active := false
+	for _, part := range partitions {
+		assigned := part.ClientID != unassignedClientID
+		active = active || assigned
+		for _, m := range p.metrics {
+			if assigned {
+				p.sendGaugeOrLog(c, describedAt, m.partitionOwnerInfo, 1, groupname, part.Topic, part.PartitionID, part.ClientID, part.ConsumerAddress)
+			}
+			p.sendStateSet(c, describedAt, m.partitionAssignment, assigned, "assigned", "unassigned", groupname, part.Topic, part.PartitionID)
+		}
+	}
+	for _, m := range p.metrics {
+		p.sendStateSet(c, describedAt, m.groupState, active, "active", "empty", groupname)
+	}
+}
+
+// unassignedClientID is the client ID Kafka reports for partitions without a
+// consumer.
+const unassignedClientID = "-"
+
+// sendStateSet exports a stateset with the two states set and unset, where the
+// state label is the last label of desc.
+func (p *PartitionInfoCollector) sendStateSet(c chan<- prometheus.Metric, describedAt time.Time, desc *prometheus.Desc, isSet bool, set, unset string, labelValues ...string) {
+	var value int64
+	if isSet {
+		value = 1
+	}
+	p.sendGaugeOrLog(c, describedAt, desc, value, append(labelValues, set)...)
+	p.sendGaugeOrLog(c, describedAt, desc, 1-value, append(labelValues, unset)...)
+}
+
+// exemplarMetric wraps a counter Metric and attaches an exemplar to it. The
+// client library in use has no other way to add exemplars to const metrics.
+type exemplarMetric struct {
+	prometheus.Metric
+	exemplar *dto.Exemplar
+}
+
+func newExemplarMetric(delegate prometheus.Metric, value float64, t time.Time, labelPairs ...string) (*exemplarMetric, error) {
+	ts, err := ptypes.TimestampProto(t)
+	if err != nil {
+		return nil, err
+	}
+	exemplar := &dto.Exemplar{
+		Value:     proto.Float64(value),
+		Timestamp: ts,
+	}
+	for i := 0; i+1 < len(labelPairs); i += 2 {
+		exemplar.Label = append(exemplar.Label, &dto.LabelPair{
+			Name:  proto.String(labelPairs[i]),
+			Value: proto.String(labelPairs[i+1]),
+		})
+	}
+	return &exemplarMetric{delegate, exemplar}, nil
+}
+
+func (e *exemplarMetric) Write(dest *dto.Metric) error {
+	if err := e.Metric.Write(dest); err != nil {
+		return err
+	}
+	if dest.Counter != nil {
+		dest.Counter.Exemplar = e.exemplar
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// PushTarget is somewhere metrics can be pushed to.
+type PushTarget interface {
+	// Push sends families to the target. Errors worth retrying are returned
+	// as *exporter.RetryableError.
+	Push(ctx context.Context, families []*dto.MetricFamily) error
+}
+
+// Pusher periodically gathers metrics and pushes them to a PushTarget, for
+// Kafka clusters Prometheus cannot scrape. The gathered metrics are split into
+// batches of at most maxSamplesPerBatch samples, and each batch is retried on
+// transient errors with jittered, exponentially growing backoff.
+type Pusher struct {
+	gatherer           prometheus.Gatherer
+	target             PushTarget
+	interval           time.Duration
+	timeout            time.Duration
+	maxRetries         int
+	backoff            time.Duration
+	maxBackoff         time.Duration
+	maxSamplesPerBatch int
+
+	requests    *prometheus.CounterVec
+	retries     prometheus.Counter
+	samples     prometheus.Counter
+	duration    prometheus.Histogram
+	lastSuccess prometheus.Gauge
+
+	// jitter returns a random duration in [0, d). Overridden in tests.
+	jitter func(d time.Duration) time.Duration
+}
+
+// NewPusher returns a Pusher pushing the metrics of gatherer to target every
+// interval. Each push must complete within timeout, including retries. A
+// maxSamplesPerBatch of zero pushes everything in a single batch.
+func NewPusher(gatherer prometheus.Gatherer, target PushTarget, interval, timeout time.Duration, maxRetries int, backoff, maxBackoff time.Duration, maxSamplesPerBatch int) *Pusher {
+	return &Pusher{
+		gatherer:           gatherer,
+		target:             target,
+		interval:           interval,
+		timeout:            timeout,
+		maxRetries:         maxRetries,
+		backoff:            backoff,
+		maxBackoff:         maxBackoff,
+		maxSamplesPerBatch: maxSamplesPerBatch,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_push_requests_total",
+			Help: "Number of push requests by result.",
+		}, []string{"result"}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_push_retries_total",
+			Help: "Number of retried push requests due to transient errors.",
+		}),
+		samples: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_push_samples_total",
+			Help: "Number of samples pushed successfully.",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "kafka_consumer_group_exporter_push_duration_seconds",
+			Help:    "Time taken to gather and push all metrics, including retries.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "kafka_consumer_group_exporter_last_push_success_timestamp_seconds",
+			Help: "When all metrics were last pushed successfully.",
+		}),
+		jitter: func(d time.Duration) time.Duration {
+			if d <= 0 {
+				return 0
+			}
+			return time.Duration(rand.Int63n(int64(d)))
+		},
+	}
+}
+
+// Run pushes every interval until ctx is done.
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.PushOnce(ctx); err != nil {
+			log.WithError(err).Error("Could not push metrics")
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// PushOnce gathers all metrics and pushes them once.
+func (p *Pusher) PushOnce(ctx context.Context) error {
+	start := time.Now()
+	defer func() {
+		p.duration.Observe(time.Since(start).Seconds())
+	}()
+
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		// Gather returns whatever it could gather along with the error, which
+		// is still worth pushing.
+		log.WithError(err).Warn("Could not gather all metrics")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	for _, batch := range splitFamilies(families, p.maxSamplesPerBatch) {
+		if err := p.pushBatch(ctx, batch); err != nil {
+			return err
+		}
+	}
+	p.lastSuccess.SetToCurrentTime()
+	return nil
+}
+
+func (p *Pusher) pushBatch(ctx context.Context, batch []*dto.MetricFamily) error {
+	backoff := p.backoff
+	for attempt := 0; ; attempt++ {
+		err := p.target.Push(ctx, batch)
+		if err == nil {
+			p.requests.WithLabelValues("success").Inc()
+			p.samples.Add(float64(countSamples(batch)))
+			return nil
+		}
+		p.requests.WithLabelValues("failure").Inc()
+		if _, retryable := err.(*exporter.RetryableError); !retryable || attempt >= p.maxRetries {
+			return err
+		}
+
+		delay := p.jitter(backoff)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		log.WithError(err).WithField("delay", delay).Info("Retrying push after transient error")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		p.retries.Inc()
+
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+// Describe transmits all metric descriptions to ch.
+func (p *Pusher) Describe(ch chan<- *prometheus.Desc) {
+	p.requests.Describe(ch)
+	p.retries.Describe(ch)
+	p.samples.Describe(ch)
+	p.duration.Describe(ch)
+	p.lastSuccess.Describe(ch)
+}
+
+// Collect transmits the push metrics into ch.
+func (p *Pusher) Collect(ch chan<- prometheus.Metric) {
+	p.requests.Collect(ch)
+	p.retries.Collect(ch)
+	p.samples.Collect(ch)
+	p.duration.Collect(ch)
+	p.lastSuccess.Collect(ch)
+}
+
+// countSamples returns the number of metrics in families.
+func countSamples(families []*dto.MetricFamily) int {
+	n := 0
+	for _, family := range families {
+		n += len(family.Metric)
+	}
+	return n
+}
+
+// splitFamilies splits families into batches of at most max metrics. A family
+// with more metrics than that is split across batches. A max of zero or less
+// returns all families in a single batch.
+func splitFamilies(families []*dto.MetricFamily, max int) [][]*dto.MetricFamily {
+	if max <= 0 || countSamples(families) <= max {
+		return [][]*dto.MetricFamily{families}
+	}
+
+	var batches [][]*dto.MetricFamily
+	var batch []*dto.MetricFamily
+	size := 0
+	for _, family := range families {
+		metrics := family.Metric
+		for len(metrics) > 0 {
+			n := len(metrics)
+			if n > max-size {
+				n = max - size
+			}
+			batch = append(batch, &dto.MetricFamily{
+				Name:   family.Name,
+				Help:   family.Help,
+				Type:   family.Type,
+				Metric: metrics[:n],
+			})
+			metrics = metrics[n:]
+			size += n
+			if size == max {
+				batches = append(batches, batch)
+				batch, size = nil, 0
+			}
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// PushgatewayTarget pushes metrics to a Prometheus Pushgateway, replacing all
+// metrics previously pushed under the same job. Since every push replaces the
+// previous one, it must not be used with batching.
+type PushgatewayTarget struct {
+	url    string
+	job    string
+	client *http.Client
+}
+
+// NewPushgatewayTarget returns a PushgatewayTarget pushing to the Pushgateway
+// at url, grouped by job.
+func NewPushgatewayTarget(url, job string, client *http.Client) *PushgatewayTarget {
+	return &PushgatewayTarget{
+		url:    url,
+		job:    job,
+		client: client,
+	}
+}
+
+// Push replaces the metrics of the job with families. The Pushgateway rejects
+// samples with explicit timestamps, so they are dropped.
+func (t *PushgatewayTarget) Push(ctx context.Context, families []*dto.MetricFamily) error {
+	withoutTimestamps := make([]*dto.MetricFamily, len(families))
+	for i, family := range families {
+		family = proto.Clone(family).(*dto.MetricFamily)
+		for _, metric := range family.Metric {
+			metric.TimestampMs = nil
+		}
+		withoutTimestamps[i] = family
+	}
+
+	doer := &pushgatewayDoer{ctx: ctx, client: t.client}
+	err := push.New(t.url, t.job).
+		Gatherer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return withoutTimestamps, nil
+		})).
+		Client(doer).
+		Push()
+	if err != nil && doer.retryable {
+		return &exporter.RetryableError{Err: err}
+	}
+	return err
+}
+
+// pushgatewayDoer attaches a context to the requests of the push package, and
+// remembers whether they failed in a way worth retrying.
+type pushgatewayDoer struct {
+	ctx       context.Context
+	client    *http.Client
+	retryable bool
+}
+
+func (d *pushgatewayDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.Do(req.WithContext(d.ctx))
+	if err != nil {
+		d.retryable = true
+		return nil, err
+	}
+	d.retryable = isRetryableStatus(resp.StatusCode)
+	return resp, nil
+}
+
+// isRetryableStatus returns whether an HTTP status code signals a transient
+// error.
+func isRetryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// errUnexpectedStatus builds the error of a push answered with code.
+func errUnexpectedStatus(url string, code int, body []byte) error {
+	err := fmt.Errorf("unexpected status code %d while pushing to %s: %s", code, url, body)
+	if isRetryableStatus(code) {
+		return &exporter.RetryableError{Err: err}
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// recordingTarget records the batches pushed to it, failing with the errors
+// in errs first.
+type recordingTarget struct {
+	errs    []error
+	batches [][]*dto.MetricFamily
+}
+
+func (r *recordingTarget) Push(ctx context.Context, families []*dto.MetricFamily) error {
+	if len(r.errs) > 0 {
+		err := r.errs[0]
+		r.errs = r.errs[1:]
+		return err
+	}
+	r.batches = append(r.batches, families)
+	return nil
+}
+
+func newTestPusher(t *testing.T, target PushTarget, maxSamplesPerBatch int) *Pusher {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newTestCollector(t, context.Background(), mocks.NewBasicConsumerGroupsCommandClient()))
+	pusher := NewPusher(registry, target, time.Minute, time.Minute, 3, time.Millisecond, time.Millisecond, maxSamplesPerBatch)
+	pusher.jitter = func(d time.Duration) time.Duration { return d }
+	return pusher
+}
+
+func TestPusherBatches(t *testing.T) {
+	target := &recordingTarget{}
+	pusher := newTestPusher(t, target, 2)
+
+	if err := pusher.PushOnce(context.Background()); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	// 2 partition gauges and the list error counter.
+	if len(target.batches) != 2 || countSamples(target.batches[0]) != 2 || countSamples(target.batches[1]) != 1 {
+		t.Error("Unexpected batches:", target.batches)
+	}
+
+	var m dto.Metric
+	pusher.samples.Write(&m)
+	if m.Counter.GetValue() != 3 {
+		t.Error("Expected 3 pushed samples. Was:", m.Counter.GetValue())
+	}
+}
+
+func TestPusherRetries(t *testing.T) {
+	target := &recordingTarget{errs: []error{
+		&exporter.RetryableError{Err: errors.New("unavailable")},
+		&exporter.RetryableError{Err: errors.New("unavailable")},
+	}}
+	pusher := newTestPusher(t, target, 0)
+
+	if err := pusher.PushOnce(context.Background()); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(target.batches) != 1 {
+		t.Error("Expected a single batch. Got:", target.batches)
+	}
+	var m dto.Metric
+	pusher.retries.Write(&m)
+	if m.Counter.GetValue() != 2 {
+		t.Error("Expected 2 retries. Was:", m.Counter.GetValue())
+	}
+
+	target.errs = []error{errors.New("bad request")}
+	if err := pusher.PushOnce(context.Background()); err == nil {
+		t.Error("Expected a permanent error not to be retried.")
+	}
+	pusher.requests.WithLabelValues("failure").Write(&m)
+	if m.Counter.GetValue() != 3 {
+		t.Error("Expected 3 failed requests. Was:", m.Counter.GetValue())
+	}
+}
+
+func TestSplitFamilies(t *testing.T) {
+	family := func(n int) *dto.MetricFamily {
+		return &dto.MetricFamily{Metric: make([]*dto.Metric, n)}
+	}
+
+	if batches := splitFamilies([]*dto.MetricFamily{family(5), family(1)}, 0); len(batches) != 1 {
+		t.Error("Expected a single batch without a maximum. Got:", batches)
+	}
+	batches := splitFamilies([]*dto.MetricFamily{family(5), family(1)}, 2)
+	if len(batches) != 3 {
+		t.Fatal("Expected 3 batches. Got:", batches)
+	}
+	for _, batch := range batches {
+		if countSamples(batch) != 2 {
+			t.Error("Expected 2 samples per batch. Got:", batch)
+		}
+	}
+}
+
+func TestPushgatewayTarget(t *testing.T) {
+	var families []*dto.MetricFamily
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/metrics/job/kafka" {
+			t.Error("Unexpected request:", r.Method, r.URL.Path)
+		}
+		decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+		for {
+			var family dto.MetricFamily
+			if err := decoder.Decode(&family); err != nil {
+				break
+			}
+			families = append(families, &family)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	target := NewPushgatewayTarget(server.URL, "kafka", http.DefaultClient)
+	pusher := newTestPusher(t, target, 0)
+	if err := pusher.PushOnce(context.Background()); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if countSamples(families) != 3 {
+		t.Error("Expected 3 samples to be pushed. Got:", families)
+	}
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			if metric.TimestampMs != nil {
+				t.Error("Expected timestamps to be dropped. Got:", metric)
+			}
+		}
+	}
+
+	status = http.StatusBadGateway
+	if _, ok := target.Push(context.Background(), families).(*exporter.RetryableError); !ok {
+		t.Error("Expected a server error to be retryable.")
+	}
+	status = http.StatusBadRequest
+	if err := target.Push(context.Background(), families); err == nil {
+		t.Error("Expected an error.")
+	} else if _, ok := err.(*exporter.RetryableError); ok {
+		t.Error("Expected a client error not to be retryable.")
+	}
+}
@@ -0,0 +1,193 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteTarget pushes metrics to a Prometheus remote write endpoint.
+type RemoteWriteTarget struct {
+	url string
+	// labels are added to every series, like the target labels of a scrape,
+	// so that series pushed by different exporters don't collide.
+	labels []remoteWriteLabel
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewRemoteWriteTarget returns a RemoteWriteTarget pushing to url. Every series
+// gets the label job=job, and externalLabels, such as instance, unless it has
+// a label of the same name already.
+func NewRemoteWriteTarget(url, job string, externalLabels map[string]string, client *http.Client) *RemoteWriteTarget {
+	labels := []remoteWriteLabel{{"job", job}}
+	for name, value := range externalLabels {
+		if name != "job" {
+			labels = append(labels, remoteWriteLabel{name, value})
+		}
+	}
+	return &RemoteWriteTarget{
+		url:    url,
+		labels: labels,
+		client: client,
+		now:    time.Now,
+	}
+}
+
+// Push sends families as a single remote write request. Samples without
+// explicit timestamp are sent with the current time.
+func (t *RemoteWriteTarget) Push(ctx context.Context, families []*dto.MetricFamily) error {
+	body := snappy.Encode(nil, encodeWriteRequest(families, t.labels, t.now().UnixNano()/nanosPerMillis))
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return &exporter.RetryableError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errUnexpectedStatus(t.url, resp.StatusCode, body)
+	}
+	return nil
+}
+
+// remoteWriteLabel and remoteWriteSeries mirror the Label and TimeSeries
+// messages of the remote write protocol.
+type remoteWriteLabel struct {
+	name, value string
+}
+
+type remoteWriteSeries struct {
+	labels      []remoteWriteLabel
+	value       float64
+	timestampMs int64
+}
+
+// toSeries flattens families into one series per sample, the way Prometheus
+// would store them after a scrape. targetLabels are added to all series not
+// having them already.
+func toSeries(families []*dto.MetricFamily, targetLabels []remoteWriteLabel, defaultTimestampMs int64) []remoteWriteSeries {
+	var series []remoteWriteSeries
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			timestampMs := defaultTimestampMs
+			if metric.TimestampMs != nil {
+				timestampMs = metric.GetTimestampMs()
+			}
+			add := func(name string, value float64, extra ...remoteWriteLabel) {
+				labels := []remoteWriteLabel{{"__name__", name}}
+				for _, pair := range metric.Label {
+					labels = append(labels, remoteWriteLabel{pair.GetName(), pair.GetValue()})
+				}
+				labels = append(labels, extra...)
+				for _, target := range targetLabels {
+					if !hasLabel(labels, target.name) {
+						labels = append(labels, target)
+					}
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+				series = append(series, remoteWriteSeries{labels, value, timestampMs})
+			}
+
+			name := family.GetName()
+			switch {
+			case metric.Gauge != nil:
+				add(name, metric.Gauge.GetValue())
+			case metric.Counter != nil:
+				add(name, metric.Counter.GetValue())
+			case metric.Untyped != nil:
+				add(name, metric.Untyped.GetValue())
+			case metric.Summary != nil:
+				for _, q := range metric.Summary.Quantile {
+					add(name, q.GetValue(), remoteWriteLabel{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", metric.Summary.GetSampleSum())
+				add(name+"_count", float64(metric.Summary.GetSampleCount()))
+			case metric.Histogram != nil:
+				for _, b := range metric.Histogram.Bucket {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					add(name+"_bucket", float64(b.GetCumulativeCount()), remoteWriteLabel{"le", formatFloat(b.GetUpperBound())})
+				}
+				add(name+"_bucket", float64(metric.Histogram.GetSampleCount()), remoteWriteLabel{"le", "+Inf"})
+				add(name+"_sum", metric.Histogram.GetSampleSum())
+				add(name+"_count", float64(metric.Histogram.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+func hasLabel(labels []remoteWriteLabel, name string) bool {
+	for _, l := range labels {
+		if l.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Field numbers of the remote write protocol messages.
+const (
+	writeRequestTimeseries = 1
+	timeSeriesLabels       = 1
+	timeSeriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// encodeWriteRequest encodes families as a remote write WriteRequest message.
+// The message is simple enough to encode by hand, which spares a dependency on
+// all of Prometheus.
+func encodeWriteRequest(families []*dto.MetricFamily, targetLabels []remoteWriteLabel, defaultTimestampMs int64) []byte {
+	var request []byte
+	for _, s := range toSeries(families, targetLabels, defaultTimestampMs) {
+		var series []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, labelName, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, labelValue, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+
+			series = protowire.AppendTag(series, timeSeriesLabels, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, sampleValue, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, sampleTimestamp, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestampMs))
+
+		series = protowire.AppendTag(series, timeSeriesSamples, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		request = protowire.AppendTag(request, writeRequestTimeseries, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
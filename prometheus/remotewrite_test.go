@@ -0,0 +1,136 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes a WriteRequest as encoded by encodeWriteRequest.
+func decodeWriteRequest(t *testing.T, b []byte) []remoteWriteSeries {
+	var series []remoteWriteSeries
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeTag(b)
+		seriesBytes, m := protowire.ConsumeBytes(b[n:])
+		if m < 0 {
+			t.Fatal("Could not decode time series.")
+		}
+		b = b[n+m:]
+
+		var s remoteWriteSeries
+		for len(seriesBytes) > 0 {
+			num, _, n := protowire.ConsumeTag(seriesBytes)
+			field, m := protowire.ConsumeBytes(seriesBytes[n:])
+			seriesBytes = seriesBytes[n+m:]
+			switch num {
+			case timeSeriesLabels:
+				_, _, n := protowire.ConsumeTag(field)
+				name, m := protowire.ConsumeString(field[n:])
+				field = field[n+m:]
+				_, _, n = protowire.ConsumeTag(field)
+				value, _ := protowire.ConsumeString(field[n:])
+				s.labels = append(s.labels, remoteWriteLabel{name, value})
+			case timeSeriesSamples:
+				_, _, n := protowire.ConsumeTag(field)
+				value, m := protowire.ConsumeFixed64(field[n:])
+				field = field[n+m:]
+				_, _, n = protowire.ConsumeTag(field)
+				timestamp, _ := protowire.ConsumeVarint(field[n:])
+				s.value = math.Float64frombits(value)
+				s.timestampMs = int64(timestamp)
+			}
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+func TestRemoteWriteTarget(t *testing.T) {
+	var received []remoteWriteSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Error("Unexpected headers:", r.Header)
+		}
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Error("Could not decompress request:", err)
+		}
+		received = decodeWriteRequest(t, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "lag", Help: "lag"}, []string{"topic"})
+	gauge.WithLabelValues("testtopic").Set(99)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency", Help: "latency", Buckets: []float64{1}})
+	histogram.Observe(0.5)
+	registry.MustRegister(gauge, histogram)
+	families, _ := registry.Gather()
+
+	target := NewRemoteWriteTarget(server.URL, "kafka", map[string]string{"instance": "exporter-1"}, http.DefaultClient)
+	target.now = func() time.Time { return time.Unix(1000, 0) }
+	if err := target.Push(context.Background(), families); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if len(received) != 5 {
+		t.Fatal("Expected 5 series. Got:", received)
+	}
+	lag := received[0]
+	if fmt.Sprint(lag.labels) != fmt.Sprint([]remoteWriteLabel{{"__name__", "lag"}, {"instance", "exporter-1"}, {"job", "kafka"}, {"topic", "testtopic"}}) {
+		t.Error("Unexpected labels:", lag.labels)
+	}
+	if lag.value != 99 || lag.timestampMs != 1000000 {
+		t.Error("Unexpected sample:", lag.value, lag.timestampMs)
+	}
+	if inf := received[2]; inf.labels[3] != (remoteWriteLabel{"le", "+Inf"}) || inf.value != 1 {
+		t.Error("Unexpected +Inf bucket:", inf)
+	}
+}
+
+func TestRemoteWriteTargetKeepsOwnLabels(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "up", Help: "up"}, []string{"instance"})
+	gauge.WithLabelValues("kafka-1:9092").Set(1)
+	registry.MustRegister(gauge)
+	families, _ := registry.Gather()
+
+	target := NewRemoteWriteTarget("", "kafka", map[string]string{"instance": "exporter-1", "job": "ignored"}, http.DefaultClient)
+	series := decodeWriteRequest(t, encodeWriteRequest(families, target.labels, 0))
+	if len(series) != 1 || fmt.Sprint(series[0].labels) != fmt.Sprint([]remoteWriteLabel{{"__name__", "up"}, {"instance", "kafka-1:9092"}, {"job", "kafka"}}) {
+		t.Error("Expected the series to keep its own instance, and the job to be set. Got:", series)
+	}
+}
+
+func TestRemoteWriteTargetErrors(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	target := NewRemoteWriteTarget(server.URL, "kafka", nil, http.DefaultClient)
+	families := []*dto.MetricFamily{}
+
+	if _, ok := target.Push(context.Background(), families).(*exporter.RetryableError); !ok {
+		t.Error("Expected a server error to be retryable.")
+	}
+	status = http.StatusBadRequest
+	if err := target.Push(context.Background(), families); err == nil {
+		t.Error("Expected an error.")
+	} else if _, ok := err.(*exporter.RetryableError); ok {
+		t.Error("Expected a client error not to be retryable.")
+	}
+}
@@ -0,0 +1,69 @@
+package prometheus
+
+import (
+	"sync"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+)
+
+// groupStates remembers the last known state of consumer groups between
+// scrapes.
+type groupStates struct {
+	mu     sync.Mutex
+	states map[string]*groupState
+}
+
+type groupState struct {
+	partitions  []exporter.PartitionInfo
+	lastSuccess time.Time
+	// disappeared is when the group was first missing from the list of
+	// groups. Zero as long as the group is listed.
+	disappeared time.Time
+}
+
+// listed updates which groups currently exist.
+func (g *groupStates) listed(groups []string, now time.Time) {
+	exists := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		exists[group] = true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for group, state := range g.states {
+		if exists[group] {
+			state.disappeared = time.Time{}
+		} else if state.disappeared.IsZero() {
+			state.disappeared = now
+		}
+	}
+}
+
+// described records the partitions of group, last described successfully at
+// lastSuccess.
+func (g *groupStates) described(group string, partitions []exporter.PartitionInfo, lastSuccess time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.states[group] = &groupState{
+		partitions:  partitions,
+		lastSuccess: lastSuccess,
+	}
+}
+
+// expire forgets all groups not described successfully within gracePeriod, and
+// returns a copy of the remaining ones.
+func (g *groupStates) expire(now time.Time, gracePeriod time.Duration) map[string]groupState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	remaining := make(map[string]groupState, len(g.states))
+	for group, state := range g.states {
+		if now.Sub(state.lastSuccess) > gracePeriod {
+			delete(g.states, group)
+			continue
+		}
+		remaining[group] = *state
+	}
+	return remaining
+}
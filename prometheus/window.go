@@ -0,0 +1,144 @@
+package prometheus
+
+import (
+	"sync"
+	"time"
+)
+
+// lagSample is the state of a partition at a point in time.
+type lagSample struct {
+	t      time.Time
+	lag    int64
+	offset int64
+}
+
+// lagWindow holds the samples of a partition within a sliding time window.
+// Times are always passed in, so it can be tested without a real clock.
+type lagWindow struct {
+	samples []lagSample
+}
+
+// add appends s and drops all samples older than width relative to s. s is
+// ignored unless it was taken after the last sample, since it is then the
+// same sample served again, for example from a cache.
+func (w *lagWindow) add(s lagSample, width time.Duration) {
+	if n := len(w.samples); n > 0 && !s.t.After(w.samples[n-1].t) {
+		return
+	}
+	w.samples = append(w.samples, s)
+
+	oldest := s.t.Add(-width)
+	i := 0
+	for i < len(w.samples)-1 && w.samples[i].t.Before(oldest) {
+		i++
+	}
+	w.samples = w.samples[i:]
+}
+
+// lagVelocity returns the rate of change of the lag per second, as the least
+// squares slope of all samples. It returns false if there are too few samples
+// to tell.
+func (w *lagWindow) lagVelocity() (float64, bool) {
+	n := float64(len(w.samples))
+	if n < 2 {
+		return 0, false
+	}
+
+	// Times relative to the first sample, to keep the numbers small.
+	first := w.samples[0].t
+	var sumT, sumLag, sumTT, sumTLag float64
+	for _, s := range w.samples {
+		t := s.t.Sub(first).Seconds()
+		lag := float64(s.lag)
+		sumT += t
+		sumLag += lag
+		sumTT += t * t
+		sumTLag += t * lag
+	}
+	denominator := n*sumTT - sumT*sumT
+	if denominator == 0 {
+		return 0, false
+	}
+	return (n*sumTLag - sumT*sumLag) / denominator, true
+}
+
+// consumptionRate returns the average number of offsets consumed per second.
+// It returns false if there are too few samples to tell, or if the offset went
+// backwards, for example because it was reset.
+func (w *lagWindow) consumptionRate() (float64, bool) {
+	if len(w.samples) < 2 {
+		return 0, false
+	}
+	first, last := w.samples[0], w.samples[len(w.samples)-1]
+	elapsed := last.t.Sub(first.t).Seconds()
+	if elapsed <= 0 || last.offset < first.offset {
+		return 0, false
+	}
+	return float64(last.offset-first.offset) / elapsed, true
+}
+
+// timeToZeroLag estimates how long it takes until the lag is zero, given the
+// current lag velocity. It returns false if the lag is not decreasing.
+func (w *lagWindow) timeToZeroLag() (float64, bool) {
+	if len(w.samples) == 0 {
+		return 0, false
+	}
+	lag := w.samples[len(w.samples)-1].lag
+	if lag == 0 {
+		return 0, true
+	}
+	velocity, ok := w.lagVelocity()
+	if !ok || velocity >= 0 {
+		return 0, false
+	}
+	return float64(lag) / -velocity, true
+}
+
+// partitionKey identifies a partition consumed by a consumer group.
+type partitionKey struct {
+	group     string
+	topic     string
+	partition string
+}
+
+// lagTrends holds a lagWindow per partition.
+type lagTrends struct {
+	width time.Duration
+
+	mu      sync.Mutex
+	windows map[partitionKey]*lagWindow
+}
+
+func newLagTrends(width time.Duration) *lagTrends {
+	return &lagTrends{
+		width:   width,
+		windows: make(map[partitionKey]*lagWindow),
+	}
+}
+
+// add records s for key and returns a copy of the resulting window.
+func (l *lagTrends) add(key partitionKey, s lagSample) lagWindow {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok {
+		w = &lagWindow{}
+		l.windows[key] = w
+	}
+	w.add(s, l.width)
+	return lagWindow{append([]lagSample(nil), w.samples...)}
+}
+
+// expire forgets partitions without any sample within the window.
+func (l *lagTrends) expire(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	oldest := now.Add(-l.width)
+	for key, w := range l.windows {
+		if w.samples[len(w.samples)-1].t.Before(oldest) {
+			delete(l.windows, key)
+		}
+	}
+}
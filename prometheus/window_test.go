@@ -0,0 +1,89 @@
+package prometheus
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// windowOf builds a lagWindow from lags and offsets sampled every step.
+func windowOf(start time.Time, step, width time.Duration, lags, offsets []int64) *lagWindow {
+	w := &lagWindow{}
+	for i := range lags {
+		w.add(lagSample{start.Add(time.Duration(i) * step), lags[i], offsets[i]}, width)
+	}
+	return w
+}
+
+func TestLagWindowDropsOldSamples(t *testing.T) {
+	start := time.Unix(0, 0)
+	w := windowOf(start, 30*time.Second, time.Minute, []int64{1, 2, 3, 4, 5}, []int64{0, 0, 0, 0, 0})
+
+	if len(w.samples) != 3 || w.samples[0].lag != 3 {
+		t.Error("Expected only samples within the window to be kept. Samples:", w.samples)
+	}
+}
+
+func TestLagWindowIgnoresRepeatedSamples(t *testing.T) {
+	start := time.Unix(0, 0)
+	w := windowOf(start, 10*time.Second, time.Hour, []int64{100, 80}, []int64{1000, 1200})
+	w.add(lagSample{start.Add(10 * time.Second), 80, 1200}, time.Hour)
+	w.add(lagSample{start, 100, 1000}, time.Hour)
+
+	if len(w.samples) != 2 {
+		t.Error("Expected samples not newer than the last one to be ignored. Samples:", w.samples)
+	}
+}
+
+func TestLagWindowVelocity(t *testing.T) {
+	start := time.Unix(0, 0)
+
+	w := windowOf(start, 10*time.Second, time.Hour, []int64{100}, []int64{0})
+	if _, ok := w.lagVelocity(); ok {
+		t.Error("Expected no velocity from a single sample.")
+	}
+	if _, ok := w.consumptionRate(); ok {
+		t.Error("Expected no consumption rate from a single sample.")
+	}
+
+	w = windowOf(start, 10*time.Second, time.Hour, []int64{100, 80, 70, 40}, []int64{1000, 1100, 1200, 1300})
+	velocity, ok := w.lagVelocity()
+	if !ok || math.Abs(velocity-(-1.9)) > 1e-9 {
+		t.Error("Unexpected lag velocity:", velocity, ok)
+	}
+	rate, ok := w.consumptionRate()
+	if !ok || math.Abs(rate-10) > 1e-9 {
+		t.Error("Unexpected consumption rate:", rate, ok)
+	}
+	ttz, ok := w.timeToZeroLag()
+	if !ok || math.Abs(ttz-40/1.9) > 1e-9 {
+		t.Error("Unexpected time to zero lag:", ttz, ok)
+	}
+}
+
+func TestLagWindowIncreasingLag(t *testing.T) {
+	start := time.Unix(0, 0)
+	w := windowOf(start, time.Second, time.Hour, []int64{10, 20, 30}, []int64{100, 50, 60})
+
+	if velocity, ok := w.lagVelocity(); !ok || velocity != 10 {
+		t.Error("Unexpected lag velocity:", velocity, ok)
+	}
+	if _, ok := w.timeToZeroLag(); ok {
+		t.Error("Expected no time to zero lag when lag is increasing.")
+	}
+	if _, ok := w.consumptionRate(); ok {
+		t.Error("Expected no consumption rate when the offset went backwards.")
+	}
+}
+
+func TestLagTrendsExpire(t *testing.T) {
+	trends := newLagTrends(time.Minute)
+	start := time.Unix(0, 0)
+	trends.add(partitionKey{"group", "topic", "0"}, lagSample{start, 1, 1})
+	trends.add(partitionKey{"group", "topic", "1"}, lagSample{start.Add(time.Minute), 1, 1})
+
+	trends.expire(start.Add(90 * time.Second))
+	if len(trends.windows) != 1 {
+		t.Error("Expected only the recently sampled partition to be kept. Windows:", trends.windows)
+	}
+}
@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// InfluxSink writes snapshots to InfluxDB over HTTP, using the line protocol.
+type InfluxSink struct {
+	url         string
+	measurement string
+	client      *http.Client
+}
+
+// NewInfluxSink returns an InfluxSink writing to url, which includes the
+// database, for example http://localhost:8086/write?db=kafka. Each partition
+// becomes a point of measurement.
+func NewInfluxSink(url, measurement string, client *http.Client) *InfluxSink {
+	return &InfluxSink{
+		url:         url,
+		measurement: measurement,
+		client:      client,
+	}
+}
+
+// Send writes one point per partition in a single request.
+func (s *InfluxSink) Send(ctx context.Context, snapshots []Snapshot) error {
+	var body bytes.Buffer
+	for _, snapshot := range snapshots {
+		for _, part := range snapshot.Partitions {
+			body.WriteString(influxMeasurementReplacer.Replace(s.measurement))
+			for _, tag := range [][2]string{
+				{"group_id", snapshot.Group},
+				{"topic", part.Topic},
+				{"partition", part.PartitionID},
+				{"client_id", part.ClientID},
+				{"consumer_address", part.ConsumerAddress},
+			} {
+				// Empty tag values are not allowed.
+				if tag[1] == "" {
+					continue
+				}
+				fmt.Fprintf(&body, ",%s=%s", tag[0], influxKeyReplacer.Replace(tag[1]))
+			}
+			fmt.Fprintf(&body, " current_offset=%di,offset_lag=%di %d\n", part.CurrentOffset, part.Lag, snapshot.Time.UnixNano())
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d while writing to %s: %s", resp.StatusCode, s.url, msg)
+	}
+	return nil
+}
+
+// influxMeasurementReplacer escapes measurement names, and influxKeyReplacer
+// tag values.
+var (
+	influxMeasurementReplacer = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", "")
+	influxKeyReplacer         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", "")
+)
@@ -0,0 +1,39 @@
+package sink
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInfluxSink(t *testing.T) {
+	var body string
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("db") != "kafka" {
+			t.Error("Unexpected query:", r.URL.RawQuery)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewInfluxSink(server.URL+"/write?db=kafka", "kafka consumer", http.DefaultClient)
+	if err := sink.Send(context.Background(), testSnapshots); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := `kafka\ consumer,group_id=my.group,topic=testtopic,partition=0,client_id=consumer-99,consumer_address=127.0.0.1 current_offset=9999i,offset_lag=99i 1000000000000
+kafka\ consumer,group_id=my.group,topic=testtopic,partition=1,client_id=-,consumer_address=- current_offset=100i,offset_lag=1i 1000000000000
+`
+	if body != expected {
+		t.Error("Unexpected body:", body)
+	}
+
+	status = http.StatusBadRequest
+	if err := sink.Send(context.Background(), testSnapshots); err == nil {
+		t.Error("Expected an error.")
+	}
+}
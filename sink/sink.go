@@ -0,0 +1,154 @@
+// Package sink sends consumer group snapshots to monitoring systems other
+// than Prometheus.
+package sink
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Snapshot holds the partitions of a consumer group at a point in time.
+type Snapshot struct {
+	Group      string
+	Partitions []exporter.PartitionInfo
+	Time       time.Time
+}
+
+// Sink receives consumer group snapshots.
+type Sink interface {
+	Send(ctx context.Context, snapshots []Snapshot) error
+}
+
+// SnapshotSource holds the latest results of the collector, such as a
+// sync.RecordingConsumerGroupInfoClient.
+type SnapshotSource interface {
+	Status() sync.RecordedStatus
+}
+
+// Poller periodically sends the latest results of the collector to all of its
+// sinks. It does not query Kafka itself, so that consumer groups are not
+// described twice.
+type Poller struct {
+	source SnapshotSource
+	// collector is collected when nothing was collected since the last
+	// send, such as when Prometheus does not scrape the exporter.
+	collector prometheus.Collector
+	interval  time.Duration
+	timeout   time.Duration
+
+	names []string
+	sinks []Sink
+
+	sends *prometheus.CounterVec
+
+	// lastList is when groups were listed for the last send.
+	lastList time.Time
+}
+
+// NewPoller returns a Poller sending the results recorded by source every
+// interval. If collector has not run since the last send, it is collected
+// first. Each send must complete within timeout.
+func NewPoller(source SnapshotSource, collector prometheus.Collector, interval, timeout time.Duration) *Poller {
+	return &Poller{
+		source:    source,
+		collector: collector,
+		interval:  interval,
+		timeout:   timeout,
+		sends: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_sink_sends_total",
+			Help: "Number of snapshots sent to each sink by result.",
+		}, []string{"sink", "result"}),
+	}
+}
+
+// AddSink adds a sink, identified by name in the metrics. It must not be
+// called once the Poller is running.
+func (p *Poller) AddSink(name string, sink Sink) {
+	p.names = append(p.names, name)
+	p.sinks = append(p.sinks, sink)
+}
+
+// Run polls every interval until ctx is done.
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.PollOnce(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// PollOnce sends the latest snapshot of all consumer groups to all sinks.
+func (p *Poller) PollOnce(ctx context.Context) {
+	status := p.source.Status()
+	if p.collector != nil && !status.LastList.After(p.lastList) {
+		collect(p.collector)
+		status = p.source.Status()
+	}
+	if status.LastList.IsZero() {
+		log.WithField("error", status.ListError).Error("Could not list groups")
+		return
+	}
+	p.lastList = status.LastList
+
+	snapshots := Snapshots(status)
+	for i, sink := range p.sinks {
+		sendCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		err := sink.Send(sendCtx, snapshots)
+		cancel()
+		if err != nil {
+			log.WithField("sink", p.names[i]).WithError(err).Error("Could not send snapshots")
+			p.sends.WithLabelValues(p.names[i], "failure").Inc()
+		} else {
+			p.sends.WithLabelValues(p.names[i], "success").Inc()
+		}
+	}
+}
+
+// collect runs collector and discards the metrics, for the results to be
+// recorded.
+func collect(collector prometheus.Collector) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(metrics)
+		close(metrics)
+	}()
+	for range metrics {
+	}
+}
+
+// Snapshots returns the snapshots of all consumer groups in status, sorted by
+// group. Groups which have never been described successfully are left out.
+// Others have the last known partitions, timestamped with when they were
+// described.
+func Snapshots(status sync.RecordedStatus) []Snapshot {
+	var snapshots []Snapshot
+	for _, group := range status.Groups {
+		if group.LastSuccess.IsZero() {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{group.Group, group.Partitions, group.LastSuccess})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Group < snapshots[j].Group })
+	return snapshots
+}
+
+// Describe transmits all metric descriptions to ch.
+func (p *Poller) Describe(ch chan<- *prometheus.Desc) {
+	p.sends.Describe(ch)
+}
+
+// Collect transmits the sink metrics into ch.
+func (p *Poller) Collect(ch chan<- prometheus.Metric) {
+	p.sends.Collect(ch)
+}
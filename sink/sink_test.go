@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type recordingSink struct {
+	err       error
+	snapshots []Snapshot
+}
+
+func (r *recordingSink) Send(_ context.Context, snapshots []Snapshot) error {
+	r.snapshots = snapshots
+	return r.err
+}
+
+// describingCollector lists and describes all groups through client when
+// collected, like the exporter's collector.
+type describingCollector struct {
+	client   *sync.RecordingConsumerGroupInfoClient
+	collects int
+}
+
+func (c *describingCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *describingCollector) Collect(chan<- prometheus.Metric) {
+	c.collects++
+	groups, _ := c.client.Groups(context.Background())
+	for _, group := range groups {
+		c.client.DescribeGroup(context.Background(), group)
+	}
+}
+
+func TestPollerSnapshots(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	client.GroupsFn = func() ([]string, error) {
+		return []string{"default", "broken", "stale"}, nil
+	}
+	lastSuccess := time.Unix(1000, 0)
+	describe := client.DescribeGroupFn
+	client.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		switch group {
+		case "broken":
+			return nil, errors.New("timed out")
+		case "stale":
+			partitions, _ := describe("default")
+			return partitions, &exporter.StaleError{Err: errors.New("timed out"), LastSuccess: lastSuccess}
+		}
+		return describe(group)
+	}
+	recordingClient := sync.NewRecordingConsumerGroupInfoClient(client)
+	collector := &describingCollector{client: recordingClient}
+	poller := NewPoller(recordingClient, collector, time.Minute, time.Minute)
+	sink := &recordingSink{}
+	poller.AddSink("recording", sink)
+
+	poller.PollOnce(context.Background())
+
+	if collector.collects != 1 {
+		t.Error("Expected the collector to be collected once. Was:", collector.collects)
+	}
+	if len(sink.snapshots) != 2 {
+		t.Fatal("Expected snapshots of the default and stale groups. Got:", sink.snapshots)
+	}
+	if s := sink.snapshots[0]; s.Group != "default" || len(s.Partitions) != 1 || s.Time.IsZero() {
+		t.Error("Unexpected snapshot:", s)
+	}
+	if s := sink.snapshots[1]; s.Group != "stale" || len(s.Partitions) != 1 || s.Time != lastSuccess {
+		t.Error("Expected the stale snapshot to be timestamped with its last success. Got:", s)
+	}
+}
+
+func TestPollerSendsCollectedResults(t *testing.T) {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	recordingClient := sync.NewRecordingConsumerGroupInfoClient(client)
+	collector := &describingCollector{client: recordingClient}
+	poller := NewPoller(recordingClient, collector, time.Minute, time.Minute)
+	sink := &recordingSink{}
+	poller.AddSink("recording", sink)
+
+	// Scraped in between sends.
+	collector.Collect(nil)
+	poller.PollOnce(context.Background())
+	if collector.collects != 1 || client.DescribeGroupInvocations != 1 {
+		t.Error("Expected the scraped results to be sent without describing again. Describes:", client.DescribeGroupInvocations)
+	}
+	if len(sink.snapshots) != 1 {
+		t.Error("Expected the scraped group to be sent. Got:", sink.snapshots)
+	}
+
+	// Not scraped since the last send.
+	poller.PollOnce(context.Background())
+	if collector.collects != 2 || client.DescribeGroupInvocations != 2 {
+		t.Error("Expected the collector to be collected. Describes:", client.DescribeGroupInvocations)
+	}
+}
+
+func TestPollerCountsSends(t *testing.T) {
+	recordingClient := sync.NewRecordingConsumerGroupInfoClient(mocks.NewBasicConsumerGroupsCommandClient())
+	poller := NewPoller(recordingClient, &describingCollector{client: recordingClient}, time.Minute, time.Minute)
+	sink := &recordingSink{}
+	poller.AddSink("recording", sink)
+
+	poller.PollOnce(context.Background())
+	sink.err = errors.New("unreachable")
+	poller.PollOnce(context.Background())
+
+	for _, result := range []string{"success", "failure"} {
+		var m dto.Metric
+		poller.sends.WithLabelValues("recording", result).Write(&m)
+		if m.Counter.GetValue() != 1 {
+			t.Errorf("Expected 1 send with result %s. Was: %f", result, m.Counter.GetValue())
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// maxStatsdPacketSize keeps datagrams below the usual Ethernet MTU, so they
+// are not fragmented.
+const maxStatsdPacketSize = 1432
+
+// StatsdSink sends snapshots as StatsD gauges over UDP. Plain StatsD has no
+// tags, so consumer group, topic and partition are part of the metric names.
+// DogStatsD gets them as tags instead.
+type StatsdSink struct {
+	conn      net.Conn
+	prefix    string
+	dogstatsd bool
+}
+
+// NewStatsdSink returns a StatsdSink sending to address, with all metric names
+// prefixed with prefix.
+func NewStatsdSink(address, prefix string, dogstatsd bool) (*StatsdSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsdSink{
+		conn:      conn,
+		prefix:    prefix,
+		dogstatsd: dogstatsd,
+	}, nil
+}
+
+// Send sends the current offset and lag of every partition, and the total lag
+// of every consumer group. Lines are packed into as few datagrams as possible.
+func (s *StatsdSink) Send(_ context.Context, snapshots []Snapshot) error {
+	var packet bytes.Buffer
+	send := func(line string) error {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsdPacketSize {
+			if _, err := s.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+		return nil
+	}
+
+	for _, snapshot := range snapshots {
+		var totalLag int64
+		for _, part := range snapshot.Partitions {
+			totalLag += part.Lag
+			tags := []string{
+				"group:" + snapshot.Group,
+				"topic:" + part.Topic,
+				"partition:" + part.PartitionID,
+				"client_id:" + part.ClientID,
+			}
+			for _, line := range []string{
+				s.gauge([]string{snapshot.Group, part.Topic, part.PartitionID}, "current_offset", part.CurrentOffset, tags),
+				s.gauge([]string{snapshot.Group, part.Topic, part.PartitionID}, "offset_lag", part.Lag, tags),
+			} {
+				if err := send(line); err != nil {
+					return err
+				}
+			}
+		}
+		if err := send(s.gauge([]string{snapshot.Group}, "total_lag", totalLag, []string{"group:" + snapshot.Group})); err != nil {
+			return err
+		}
+	}
+	if packet.Len() > 0 {
+		_, err := s.conn.Write(packet.Bytes())
+		return err
+	}
+	return nil
+}
+
+// gauge formats a gauge line. path is only used for plain StatsD, and tags
+// only for DogStatsD.
+func (s *StatsdSink) gauge(path []string, name string, value int64, tags []string) string {
+	if s.dogstatsd {
+		for i, tag := range tags {
+			tags[i] = dogstatsdTagReplacer.Replace(tag)
+		}
+		return fmt.Sprintf("%s.%s:%d|g|#%s", s.prefix, name, value, strings.Join(tags, ","))
+	}
+	components := []string{s.prefix}
+	for _, component := range path {
+		components = append(components, sanitizeStatsdComponent(component))
+	}
+	return fmt.Sprintf("%s.%s:%d|g", strings.Join(components, "."), name, value)
+}
+
+// dogstatsdTagReplacer replaces the characters separating tags and fields.
+var dogstatsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "\n", "_")
+
+// sanitizeStatsdComponent escapes all characters not safe in a StatsD metric
+// name component. Unsafe bytes become `_` and two hex digits, and `_` itself
+// becomes `__`, so that distinct names such as "my.group" and "my_group" stay
+// distinct.
+func sanitizeStatsdComponent(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+			b.WriteByte(c)
+		case c == '_':
+			b.WriteString("__")
+		default:
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// Close closes the UDP socket.
+func (s *StatsdSink) Close() error {
+	return s.conn.Close()
+}
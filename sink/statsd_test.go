@@ -0,0 +1,123 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+)
+
+// listenStatsd returns a local UDP listener and a function returning all
+// lines received by it.
+func listenStatsd(t *testing.T) (net.PacketConn, func() []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Could not listen:", err)
+	}
+	return conn, func() []string {
+		var lines []string
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return lines
+			}
+			if n > maxStatsdPacketSize {
+				t.Error("Datagram exceeds the maximum size:", n)
+			}
+			lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+		}
+	}
+}
+
+var testSnapshots = []Snapshot{{
+	Group: "my.group",
+	Partitions: []exporter.PartitionInfo{
+		{Topic: "testtopic", PartitionID: "0", CurrentOffset: 9999, Lag: 99, ClientID: "consumer-99", ConsumerAddress: "127.0.0.1"},
+		{Topic: "testtopic", PartitionID: "1", CurrentOffset: 100, Lag: 1, ClientID: "-", ConsumerAddress: "-"},
+	},
+	Time: time.Unix(1000, 0),
+}}
+
+func TestStatsdSink(t *testing.T) {
+	conn, received := listenStatsd(t)
+	defer conn.Close()
+	sink, err := NewStatsdSink(conn.LocalAddr().String(), "kafka", false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer sink.Close()
+
+	if err := sink.Send(context.Background(), testSnapshots); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	lines := received()
+	expected := []string{
+		"kafka.my_2egroup.testtopic.0.current_offset:9999|g",
+		"kafka.my_2egroup.testtopic.0.offset_lag:99|g",
+		"kafka.my_2egroup.testtopic.1.current_offset:100|g",
+		"kafka.my_2egroup.testtopic.1.offset_lag:1|g",
+		"kafka.my_2egroup.total_lag:100|g",
+	}
+	if fmt.Sprint(lines) != fmt.Sprint(expected) {
+		t.Error("Unexpected lines:", lines)
+	}
+}
+
+func TestSanitizeStatsdComponent(t *testing.T) {
+	for s, expected := range map[string]string{
+		"my-group":   "my-group",
+		"my.group":   "my_2egroup",
+		"my_group":   "my__group",
+		"my_2egroup": "my__2egroup",
+		"grüße":      "gr_c3_bc_c3_9fe",
+	} {
+		if sanitized := sanitizeStatsdComponent(s); sanitized != expected {
+			t.Errorf("Expected %q to be sanitized to %q. Was: %q", s, expected, sanitized)
+		}
+	}
+}
+
+func TestDogstatsdSink(t *testing.T) {
+	conn, received := listenStatsd(t)
+	defer conn.Close()
+	sink, err := NewStatsdSink(conn.LocalAddr().String(), "kafka", true)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer sink.Close()
+
+	if err := sink.Send(context.Background(), testSnapshots); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	lines := received()
+	if len(lines) != 5 || lines[1] != "kafka.offset_lag:99|g|#group:my.group,topic:testtopic,partition:0,client_id:consumer-99" {
+		t.Error("Unexpected lines:", lines)
+	}
+}
+
+func TestStatsdSinkSplitsPackets(t *testing.T) {
+	conn, received := listenStatsd(t)
+	defer conn.Close()
+	sink, err := NewStatsdSink(conn.LocalAddr().String(), "kafka", true)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer sink.Close()
+
+	var partitions []exporter.PartitionInfo
+	for i := 0; i < 100; i++ {
+		partitions = append(partitions, exporter.PartitionInfo{Topic: "testtopic", PartitionID: fmt.Sprint(i)})
+	}
+	if err := sink.Send(context.Background(), []Snapshot{{Group: "default", Partitions: partitions}}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if lines := received(); len(lines) != 201 {
+		t.Error("Expected 201 lines. Got:", len(lines))
+	}
+}
@@ -0,0 +1,203 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// States of a circuit breaker, as exported by
+// CircuitBreakingConsumerGroupInfoClient.
+const (
+	breakerClosed   = 0
+	breakerHalfOpen = 1
+	breakerOpen     = 2
+)
+
+// ErrCircuitOpen is returned when a group is not described because its
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreakingConsumerGroupInfoClient is a exporter.ConsumerGroupInfoClient
+// decorator that stops describing consumer groups that keep failing. Once a
+// group has failed a number of times in a row its breaker opens, and the
+// group is not described again until a backoff has passed. The backoff
+// doubles for every further failure, up to a maximum.
+//
+// While the breaker of a group is open, DescribeGroup() returns the last good
+// partitions of the group together with a *exporter.StaleError.
+type CircuitBreakingConsumerGroupInfoClient struct {
+	delegate   exporter.ConsumerGroupInfoClient
+	threshold  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	state    *prometheus.Desc
+	rejected *prometheus.CounterVec
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	// probing is set while a single call is let through to check whether the
+	// group has recovered.
+	probing bool
+
+	lastGood    []exporter.PartitionInfo
+	lastSuccess time.Time
+}
+
+func (b *breaker) state(now time.Time) int {
+	switch {
+	case b.probing:
+		return breakerHalfOpen
+	case now.Before(b.openUntil):
+		return breakerOpen
+	case !b.openUntil.IsZero():
+		return breakerHalfOpen
+	default:
+		return breakerClosed
+	}
+}
+
+// NewCircuitBreakingConsumerGroupInfoClient returns a circuit breaking
+// decorator of delegate. A group's breaker opens after threshold consecutive
+// failures, for backoff doubled for every failure after that, but never longer
+// than maxBackoff.
+func NewCircuitBreakingConsumerGroupInfoClient(delegate exporter.ConsumerGroupInfoClient, threshold int, backoff, maxBackoff time.Duration) *CircuitBreakingConsumerGroupInfoClient {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreakingConsumerGroupInfoClient{
+		delegate:   delegate,
+		threshold:  threshold,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		state: prometheus.NewDesc(
+			"kafka_consumer_group_exporter_circuit_breaker_state",
+			"State of the circuit breaker of a consumer group. 0 is closed, 1 is half-open and 2 is open.",
+			[]string{"group"}, nil),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_circuit_breaker_rejected_total",
+			Help: "Number of consumer group describes not run because the circuit breaker was open.",
+		}, []string{"group"}),
+		now:      time.Now,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// Groups calls Delegate.Groups(). Breakers and rejection counters of groups
+// that no longer exist are forgotten.
+func (c *CircuitBreakingConsumerGroupInfoClient) Groups(ctx context.Context) ([]string, error) {
+	groups, err := c.delegate.Groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		exists[group] = true
+	}
+	c.mu.Lock()
+	for group := range c.breakers {
+		if !exists[group] {
+			delete(c.breakers, group)
+			c.rejected.DeleteLabelValues(group)
+		}
+	}
+	c.mu.Unlock()
+
+	return groups, nil
+}
+
+// DescribeGroup calls Delegate.DescribeGroup() unless the breaker of group is
+// open.
+func (c *CircuitBreakingConsumerGroupInfoClient) DescribeGroup(ctx context.Context, group string) ([]exporter.PartitionInfo, error) {
+	c.mu.Lock()
+	b, ok := c.breakers[group]
+	if !ok {
+		b = &breaker{}
+		c.breakers[group] = b
+	}
+	if b.probing || c.now().Before(b.openUntil) {
+		c.mu.Unlock()
+		c.rejected.WithLabelValues(group).Inc()
+		return c.stale(b, ErrCircuitOpen)
+	}
+	if !b.openUntil.IsZero() {
+		// The backoff has passed. Let a single call through to see whether
+		// the group has recovered.
+		b.probing = true
+	}
+	c.mu.Unlock()
+
+	partitions, err := c.delegate.DescribeGroup(ctx, group)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b.probing = false
+	if err != nil {
+		b.failures++
+		if b.failures >= c.threshold {
+			b.openUntil = c.now().Add(c.backoffFor(b.failures))
+		}
+		return nil, err
+	}
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.lastGood = partitions
+	b.lastSuccess = c.now()
+	return partitions, nil
+}
+
+// backoffFor returns how long a breaker stays open after the given number of
+// consecutive failures.
+func (c *CircuitBreakingConsumerGroupInfoClient) backoffFor(failures int) time.Duration {
+	backoff := c.backoff
+	for i := c.threshold; i < failures && backoff < c.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.maxBackoff {
+		backoff = c.maxBackoff
+	}
+	return backoff
+}
+
+// stale returns the last good result of b. Must be called without c.mu held.
+func (c *CircuitBreakingConsumerGroupInfoClient) stale(b *breaker, err error) ([]exporter.PartitionInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b.lastGood == nil {
+		return nil, err
+	}
+	return b.lastGood, &exporter.StaleError{Err: err, LastSuccess: b.lastSuccess}
+}
+
+// Describe transmits all metric descriptions to ch.
+func (c *CircuitBreakingConsumerGroupInfoClient) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	c.rejected.Describe(ch)
+}
+
+// Collect transmits the breaker state of each group into ch.
+func (c *CircuitBreakingConsumerGroupInfoClient) Collect(ch chan<- prometheus.Metric) {
+	now := c.now()
+
+	c.mu.Lock()
+	for group, b := range c.breakers {
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, float64(b.state(now)), group)
+	}
+	c.mu.Unlock()
+
+	c.rejected.Collect(ch)
+}
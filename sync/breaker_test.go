@@ -0,0 +1,116 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitBreakerOpensAndServesStaleResults(t *testing.T) {
+	fail := false
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	succeed := delegate.DescribeGroupFn
+	delegate.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		if fail {
+			return nil, errors.New("timed out")
+		}
+		return succeed(group)
+	}
+	clock := &fakeClock{time.Unix(0, 0)}
+	client := NewCircuitBreakingConsumerGroupInfoClient(delegate, 2, time.Minute, 5*time.Minute)
+	client.now = clock.Now
+
+	if _, err := client.DescribeGroup(context.Background(), "default"); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	lastSuccess := clock.Now()
+
+	fail = true
+	for i := 0; i < 2; i++ {
+		clock.Advance(time.Second)
+		if _, err := client.DescribeGroup(context.Background(), "default"); err == nil || err == ErrCircuitOpen {
+			t.Fatal("Expected the delegate's error before the breaker opens. Got:", err)
+		}
+	}
+
+	partitions, err := client.DescribeGroup(context.Background(), "default")
+	stale, ok := err.(*exporter.StaleError)
+	if !ok || stale.Err != ErrCircuitOpen || !stale.LastSuccess.Equal(lastSuccess) {
+		t.Fatal("Expected a stale error from the open breaker. Got:", err)
+	}
+	if len(partitions) != 1 {
+		t.Error("Expected the last good partitions. Got:", partitions)
+	}
+	if delegate.DescribeGroupInvocations != 3 {
+		t.Error("Expected the open breaker to not call the delegate. It was called", delegate.DescribeGroupInvocations, "times.")
+	}
+
+	// A failing probe doubles the backoff.
+	clock.Advance(time.Minute)
+	client.DescribeGroup(context.Background(), "default")
+	clock.Advance(time.Minute)
+	client.DescribeGroup(context.Background(), "default")
+	if delegate.DescribeGroupInvocations != 4 {
+		t.Error("Expected the backoff to double after a failed probe. DescribeGroup() was called", delegate.DescribeGroupInvocations, "times.")
+	}
+
+	// A successful probe closes the breaker.
+	fail = false
+	clock.Advance(time.Minute)
+	if _, err := client.DescribeGroup(context.Background(), "default"); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if state := client.breakers["default"].state(clock.Now()); state != breakerClosed {
+		t.Error("Expected breaker to be closed. Was:", state)
+	}
+}
+
+func TestCircuitBreakerBackoff(t *testing.T) {
+	client := NewCircuitBreakingConsumerGroupInfoClient(nil, 3, time.Minute, 5*time.Minute)
+
+	expected := map[int]time.Duration{
+		3:  time.Minute,
+		4:  2 * time.Minute,
+		5:  4 * time.Minute,
+		6:  5 * time.Minute,
+		60: 5 * time.Minute,
+	}
+	for failures, backoff := range expected {
+		if actual := client.backoffFor(failures); actual != backoff {
+			t.Error("Unexpected backoff after", failures, "failures. Expected:", backoff, "Was:", actual)
+		}
+	}
+}
+
+func TestCircuitBreakerForgetsRemovedGroups(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	delegate.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		return nil, errors.New("timed out")
+	}
+	client := NewCircuitBreakingConsumerGroupInfoClient(delegate, 1, time.Minute, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		client.DescribeGroup(context.Background(), "default")
+	}
+	if count := testutil.CollectAndCount(client.rejected); count != 1 {
+		t.Fatal("Expected a rejection counter for the group. Found", count)
+	}
+
+	delegate.GroupsFn = func() ([]string, error) {
+		return []string{"other"}, nil
+	}
+	if _, err := client.Groups(context.Background()); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, ok := client.breakers["default"]; ok {
+		t.Error("Expected the breaker of the removed group to be forgotten.")
+	}
+	if count := testutil.CollectAndCount(client.rejected); count != 0 {
+		t.Error("Expected the rejection counter of the removed group to be deleted. Found", count)
+	}
+}
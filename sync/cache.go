@@ -0,0 +1,205 @@
+package sync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	callGroups   = "groups"
+	callDescribe = "describe"
+
+	resultHit   = "hit"
+	resultStale = "stale"
+	resultMiss  = "miss"
+)
+
+// CachingConsumerGroupInfoClient is a exporter.ConsumerGroupInfoClient
+// decorator that caches results from Delegate. Each consumer group is cached
+// separately. A result younger than the TTL is returned as is. A result older
+// than the TTL, but younger than the maximum staleness, is also returned while
+// it is being refreshed in the background. Results older than that are never
+// returned; the caller waits for a fresh call to Delegate instead, and gets
+// its error if it fails.
+//
+// This is useful to let multiple Prometheus servers scrape the same exporter
+// without multiplying the load on Kafka.
+type CachingConsumerGroupInfoClient struct {
+	delegate exporter.ConsumerGroupInfoClient
+
+	ctx            context.Context
+	ttl            time.Duration
+	maxStaleness   time.Duration
+	refreshTimeout time.Duration
+
+	requests      *prometheus.CounterVec
+	refreshErrors *prometheus.CounterVec
+	servedAge     *prometheus.HistogramVec
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}
+
+type cacheKey struct {
+	call  string
+	group string
+}
+
+type cacheEntry struct {
+	value      interface{}
+	fetched    time.Time
+	refreshing bool
+}
+
+// NewCachingConsumerGroupInfoClient returns a caching decorator of delegate.
+// Results are considered fresh for ttl, and may be served while being
+// refreshed until they are maxStaleness old. If maxStaleness is smaller than
+// ttl, no stale results are served. Background refreshes derive their context
+// from ctx and are given at most refreshTimeout to complete.
+func NewCachingConsumerGroupInfoClient(ctx context.Context, delegate exporter.ConsumerGroupInfoClient, ttl, maxStaleness, refreshTimeout time.Duration) *CachingConsumerGroupInfoClient {
+	if maxStaleness < ttl {
+		maxStaleness = ttl
+	}
+	return &CachingConsumerGroupInfoClient{
+		delegate:       delegate,
+		ctx:            ctx,
+		ttl:            ttl,
+		maxStaleness:   maxStaleness,
+		refreshTimeout: refreshTimeout,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_cache_requests_total",
+			Help: "Number of cache lookups, partitioned by call and result (hit, stale or miss).",
+		}, []string{"call", "result"}),
+		refreshErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_cache_refresh_errors_total",
+			Help: "Number of failed background refreshes of stale cache entries.",
+		}, []string{"call"}),
+		servedAge: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kafka_consumer_group_exporter_cache_served_age_seconds",
+			Help:    "Age of the cached results returned to callers.",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+		}, []string{"call"}),
+		now:     time.Now,
+		entries: make(map[cacheKey]*cacheEntry),
+	}
+}
+
+// Groups returns the cached result of Delegate.Groups(), calling it if
+// needed.
+func (c *CachingConsumerGroupInfoClient) Groups(ctx context.Context) ([]string, error) {
+	value, err := c.get(ctx, cacheKey{callGroups, ""}, func(ctx context.Context) (interface{}, error) {
+		return c.delegate.Groups(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]string), nil
+}
+
+// DescribeGroup returns the cached result of Delegate.DescribeGroup() for
+// group, calling it if needed.
+func (c *CachingConsumerGroupInfoClient) DescribeGroup(ctx context.Context, group string) ([]exporter.PartitionInfo, error) {
+	value, err := c.get(ctx, cacheKey{callDescribe, group}, func(ctx context.Context) (interface{}, error) {
+		return c.delegate.DescribeGroup(ctx, group)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]exporter.PartitionInfo), nil
+}
+
+func (c *CachingConsumerGroupInfoClient) get(ctx context.Context, key cacheKey, fetch func(context.Context) (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok {
+		age := c.now().Sub(entry.fetched)
+		if age < c.ttl {
+			c.mu.Unlock()
+			c.requests.WithLabelValues(key.call, resultHit).Inc()
+			c.servedAge.WithLabelValues(key.call).Observe(age.Seconds())
+			return entry.value, nil
+		}
+		if age < c.maxStaleness {
+			if !entry.refreshing {
+				entry.refreshing = true
+				go c.refresh(key, fetch)
+			}
+			c.mu.Unlock()
+			c.requests.WithLabelValues(key.call, resultStale).Inc()
+			c.servedAge.WithLabelValues(key.call).Observe(age.Seconds())
+			return entry.value, nil
+		}
+	}
+	c.mu.Unlock()
+
+	c.requests.WithLabelValues(key.call, resultMiss).Inc()
+	value, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.store(key, value)
+	return value, nil
+}
+
+func (c *CachingConsumerGroupInfoClient) refresh(key cacheKey, fetch func(context.Context) (interface{}, error)) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.refreshTimeout)
+	value, err := fetch(ctx)
+	cancel()
+	if err != nil {
+		entry := log.WithField("call", key.call)
+		if key.group != "" {
+			entry = entry.WithField("group", key.group)
+		}
+		entry.WithError(err).Warn("Could not refresh cached call")
+		c.refreshErrors.WithLabelValues(key.call).Inc()
+
+		c.mu.Lock()
+		if entry, ok := c.entries[key]; ok {
+			entry.refreshing = false
+		}
+		c.mu.Unlock()
+		return
+	}
+	c.store(key, value)
+}
+
+func (c *CachingConsumerGroupInfoClient) store(key cacheKey, value interface{}) {
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = &cacheEntry{value: value, fetched: now}
+
+	if key.call == callGroups {
+		// Groups that are no longer described would otherwise stay in the
+		// cache forever. Entries that can no longer be served are useless, so
+		// we drop them whenever the list of groups is refreshed.
+		for k, entry := range c.entries {
+			if !entry.refreshing && now.Sub(entry.fetched) >= c.maxStaleness {
+				delete(c.entries, k)
+			}
+		}
+	}
+}
+
+// Describe transmits all metric descriptions to ch.
+func (c *CachingConsumerGroupInfoClient) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.refreshErrors.Describe(ch)
+	c.servedAge.Describe(ch)
+}
+
+// Collect transmits the cache metrics into ch.
+func (c *CachingConsumerGroupInfoClient) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.refreshErrors.Collect(ch)
+	c.servedAge.Collect(ch)
+}
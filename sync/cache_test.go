@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.t
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.t = f.t.Add(d)
+}
+
+func newTestCachingClient(delegate exporter.ConsumerGroupInfoClient, clock *fakeClock) *CachingConsumerGroupInfoClient {
+	c := NewCachingConsumerGroupInfoClient(context.Background(), delegate, 10*time.Second, time.Minute, time.Minute)
+	c.now = clock.Now
+	return c
+}
+
+func TestCachingClientServesFreshResults(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	clock := &fakeClock{time.Unix(0, 0)}
+	cache := newTestCachingClient(delegate, clock)
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.DescribeGroup(context.Background(), "default"); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	if delegate.DescribeGroupInvocations != 1 {
+		t.Error("Expected a single call to DescribeGroup(). It was called", delegate.DescribeGroupInvocations, "times.")
+	}
+}
+
+func TestCachingClientCachesGroupsSeparately(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	clock := &fakeClock{time.Unix(0, 0)}
+	cache := newTestCachingClient(delegate, clock)
+
+	cache.DescribeGroup(context.Background(), "a")
+	cache.DescribeGroup(context.Background(), "b")
+	cache.DescribeGroup(context.Background(), "a")
+
+	if delegate.DescribeGroupInvocations != 2 {
+		t.Error("Expected one call to DescribeGroup() per group. It was called", delegate.DescribeGroupInvocations, "times.")
+	}
+}
+
+func TestCachingClientRevalidatesStaleResults(t *testing.T) {
+	refreshed := make(chan struct{})
+	calls := 0
+	delegate := &mocks.ConsumerGroupsCommandClient{
+		GroupsFn: func() ([]string, error) {
+			calls++
+			if calls > 1 {
+				defer close(refreshed)
+			}
+			return []string{"group", string(rune('0' + calls))}, nil
+		},
+	}
+	clock := &fakeClock{time.Unix(0, 0)}
+	cache := newTestCachingClient(delegate, clock)
+
+	cache.Groups(context.Background())
+	clock.Advance(30 * time.Second)
+
+	groups, err := cache.Groups(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if groups[1] != "1" {
+		t.Error("Expected the stale result to be served. Got:", groups)
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stale result was never refreshed in the background.")
+	}
+
+	// Wait for the refreshed result to be stored.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		groups, _ = cache.Groups(context.Background())
+		if groups[1] == "2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Refreshed result was never served. Got:", groups)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCachingClientDoesNotServeTooStaleResults(t *testing.T) {
+	fail := false
+	delegate := &mocks.ConsumerGroupsCommandClient{
+		DescribeGroupFn: func(group string) ([]exporter.PartitionInfo, error) {
+			if fail {
+				return nil, errors.New("describe failed")
+			}
+			return []exporter.PartitionInfo{}, nil
+		},
+	}
+	clock := &fakeClock{time.Unix(0, 0)}
+	cache := newTestCachingClient(delegate, clock)
+
+	if _, err := cache.DescribeGroup(context.Background(), "default"); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	fail = true
+	clock.Advance(2 * time.Minute)
+
+	if _, err := cache.DescribeGroup(context.Background(), "default"); err == nil {
+		t.Error("Expected an error once the cached result is older than the maximum staleness.")
+	}
+}
@@ -0,0 +1,232 @@
+package sync
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// callGroup makes sure that only a single call per key is in flight at any
+// time. Callers asking for a key that is already being computed wait for, and
+// share, the result of the ongoing call. Keys only occupy memory while a call
+// is in flight.
+//
+// The zero value is ready to use.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+	// running holds all calls in flight, including abandoned ones.
+	running map[*call]struct{}
+	idle    *sync.Cond
+}
+
+// call is a single in-flight, or completed, computation for a key.
+type call struct {
+	done    chan struct{}
+	started time.Time
+
+	// ctx is passed to the computation. It is cancelled once no subscriber
+	// is waiting for the result anymore.
+	ctx *callContext
+
+	// subscribers is the number of callers waiting for the result.
+	subscribers int
+
+	value interface{}
+	err   error
+}
+
+// do executes fn for key, unless there already is a call in flight for key,
+// in which case the result of that call is returned.
+//
+// Every caller returns as soon as its own ctx is done, without waiting for the
+// result. The context passed to fn is only cancelled once all callers waiting
+// for it have returned. Its deadline is the latest deadline of the callers,
+// and is pushed back as callers with later deadlines join. It has no deadline
+// if any caller has none. It does not carry any values from ctx.
+func (g *callGroup) do(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+		g.running = make(map[*call]struct{})
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		c = &call{
+			done:    make(chan struct{}),
+			started: time.Now(),
+			ctx:     newCallContext(ctx),
+		}
+		g.calls[key] = c
+		g.running[c] = struct{}{}
+		go g.run(key, c, fn)
+	} else {
+		c.ctx.extend(ctx)
+	}
+	c.subscribers++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.subscribers--
+		if c.subscribers == 0 {
+			c.ctx.cancel(context.Canceled)
+			// Callers arriving after this point should not be handed the
+			// result of a cancelled call.
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *callGroup) run(key string, c *call, fn func(context.Context) (interface{}, error)) {
+	c.value, c.err = fn(c.ctx)
+
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	delete(g.running, c)
+	if len(g.running) == 0 && g.idle != nil {
+		g.idle.Broadcast()
+	}
+	g.mu.Unlock()
+
+	c.ctx.cancel(context.Canceled)
+	close(c.done)
+}
+
+// wait blocks until there are no calls in flight, including calls that have
+// been abandoned by all their callers.
+func (g *callGroup) wait() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.idle == nil {
+		g.idle = sync.NewCond(&g.mu)
+	}
+	for len(g.running) > 0 {
+		g.idle.Wait()
+	}
+}
+
+// oldest returns when the longest running call in flight started, including
+// abandoned calls. It returns the zero time if there is no call in flight.
+func (g *callGroup) oldest() time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var oldest time.Time
+	for c := range g.running {
+		if oldest.IsZero() || c.started.Before(oldest) {
+			oldest = c.started
+		}
+	}
+	return oldest
+}
+
+// pending returns the number of keys with a call in flight.
+func (g *callGroup) pending() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
+
+// subscribers returns the number of callers waiting for the call in flight for
+// key.
+func (g *callGroup) subscribers(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c.subscribers
+	}
+	return 0
+}
+
+// callContext is the context passed to the computation of a call. Unlike a
+// context.WithDeadline, its deadline can be pushed back while the call is in
+// flight.
+type callContext struct {
+	done chan struct{}
+
+	mu          sync.Mutex
+	err         error
+	deadline    time.Time
+	hasDeadline bool
+	timer       *time.Timer
+}
+
+// newCallContext returns a callContext with the deadline of ctx.
+func newCallContext(ctx context.Context) *callContext {
+	c := &callContext{done: make(chan struct{})}
+	c.deadline, c.hasDeadline = ctx.Deadline()
+	if c.hasDeadline {
+		c.timer = time.AfterFunc(time.Until(c.deadline), c.expire)
+	}
+	return c
+}
+
+// extend pushes the deadline of c back to the deadline of ctx if that is
+// later, or removes it if ctx has no deadline.
+func (c *callContext) extend(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.hasDeadline || c.err != nil {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if ok && !deadline.After(c.deadline) {
+		return
+	}
+	// If the timer has already fired, c is about to expire and it is too
+	// late to extend it.
+	if !c.timer.Stop() {
+		return
+	}
+	c.deadline, c.hasDeadline = deadline, ok
+	if ok {
+		c.timer = time.AfterFunc(time.Until(deadline), c.expire)
+	}
+}
+
+func (c *callContext) expire() {
+	c.cancel(context.DeadlineExceeded)
+}
+
+// cancel cancels c with err, unless it is already done.
+func (c *callContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	close(c.done)
+}
+
+func (c *callContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline, c.hasDeadline
+}
+
+func (c *callContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *callContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *callContext) Value(key interface{}) interface{} {
+	return nil
+}
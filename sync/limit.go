@@ -0,0 +1,328 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Multiplicative decrease applied to the concurrency limit on errors and slow
+// calls.
+const limitBackoffRatio = 0.75
+
+// errRateLimitDeadline is returned when a call could not be started before
+// the deadline of its context.
+var errRateLimitDeadline = errors.New("rate limit would exceed context deadline")
+
+// AdaptiveLimiter limits the number of concurrent calls. The limit is raised
+// by one for every limit successful calls faster than the target latency, and
+// lowered multiplicatively for every failed or slower call (AIMD). The limit
+// always stays between the configured minimum and maximum.
+type AdaptiveLimiter struct {
+	min           float64
+	max           float64
+	targetLatency time.Duration
+
+	mu      sync.Mutex
+	limit   float64
+	inUse   int
+	waiters []chan struct{}
+}
+
+// NewAdaptiveLimiter returns an AdaptiveLimiter starting at max concurrent
+// calls.
+func NewAdaptiveLimiter(min, max int, targetLatency time.Duration) *AdaptiveLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &AdaptiveLimiter{
+		min:           float64(min),
+		max:           float64(max),
+		targetLatency: targetLatency,
+		limit:         float64(max),
+	}
+}
+
+// Limit returns the current concurrency limit.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InUse returns the number of calls currently holding a slot.
+func (l *AdaptiveLimiter) InUse() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inUse
+}
+
+// Acquire blocks until a slot is available or ctx is done. Each successful
+// Acquire must be followed by a call to Release or Abandon.
+func (l *AdaptiveLimiter) Acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.inUse < int(l.limit) && len(l.waiters) == 0 {
+		l.inUse++
+		l.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, waiter := range l.waiters {
+			if waiter == ready {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				return ctx.Err()
+			}
+		}
+		// We were handed a slot while giving up. Pass it on.
+		l.inUse--
+		l.wakeWaiters()
+		return ctx.Err()
+	}
+}
+
+// Release returns a slot and adjusts the limit based on how the call went.
+// Calls cut short by context.Canceled are treated like Abandon, since the
+// caller gave up rather than Kafka being slow.
+func (l *AdaptiveLimiter) Release(latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inUse--
+	if err == context.Canceled {
+		// Leave the limit as it is.
+	} else if err != nil || latency > l.targetLatency {
+		l.limit = math.Max(l.min, l.limit*limitBackoffRatio)
+	} else {
+		l.limit = math.Min(l.max, l.limit+1/l.limit)
+	}
+	l.wakeWaiters()
+}
+
+// Abandon returns a slot without adjusting the limit. It is used for calls
+// that never ran, since they say nothing about how Kafka is doing.
+func (l *AdaptiveLimiter) Abandon() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inUse--
+	l.wakeWaiters()
+}
+
+// wakeWaiters hands out free slots to waiters. Must be called with l.mu held.
+func (l *AdaptiveLimiter) wakeWaiters() {
+	for len(l.waiters) > 0 && l.inUse < int(l.limit) {
+		l.inUse++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+}
+
+// RateLimiter limits the rate at which calls are started.
+type RateLimiter struct {
+	interval time.Duration
+	burst    int
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// tat is the theoretical arrival time of the next call if calls were
+	// evenly spaced.
+	tat time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing perSecond calls per second
+// on average, with bursts of up to burst calls.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		burst:    burst,
+		now:      time.Now,
+	}
+}
+
+// reserve reserves the next call slot and returns how long the caller has to
+// wait for it. No slot is reserved if the wait would exceed deadline.
+func (r *RateLimiter) reserve(deadline time.Time, hasDeadline bool) (time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	tat := r.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	wait := tat.Sub(now) - time.Duration(r.burst-1)*r.interval
+	if wait < 0 {
+		wait = 0
+	}
+	if hasDeadline && now.Add(wait).After(deadline) {
+		return 0, errRateLimitDeadline
+	}
+	r.tat = tat.Add(r.interval)
+	return wait, nil
+}
+
+// Wait blocks until the caller is allowed to start a call. It returns an error
+// without waiting if that would take longer than the deadline of ctx.
+func (r *RateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	deadline, hasDeadline := ctx.Deadline()
+	wait, err := r.reserve(deadline, hasDeadline)
+	if err != nil || wait == 0 {
+		return 0, err
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return wait, nil
+	case <-ctx.Done():
+		return wait, ctx.Err()
+	}
+}
+
+// LimitingConsumerGroupInfoClient is a exporter.ConsumerGroupInfoClient
+// decorator that limits the load put on Kafka by Delegate. Concurrent
+// DescribeGroup() calls are limited by an AdaptiveLimiter, and all calls are
+// limited by a RateLimiter. Either limiter may be nil to disable it.
+//
+// This should be placed below any FanInConsumerGroupInfoClient, so that only
+// calls actually reaching Kafka are limited. Calls are denied rather than
+// queued when the rate limit would delay them past the latest deadline of the
+// callers sharing them.
+type LimitingConsumerGroupInfoClient struct {
+	delegate    exporter.ConsumerGroupInfoClient
+	limiter     *AdaptiveLimiter
+	rateLimiter *RateLimiter
+
+	concurrencyLimit *prometheus.Desc
+	concurrencyInUse *prometheus.Desc
+	rateLimitWait    prometheus.Counter
+	rateLimitDenied  prometheus.Counter
+}
+
+// NewLimitingConsumerGroupInfoClient returns a limiting decorator of delegate.
+func NewLimitingConsumerGroupInfoClient(delegate exporter.ConsumerGroupInfoClient, limiter *AdaptiveLimiter, rateLimiter *RateLimiter) *LimitingConsumerGroupInfoClient {
+	return &LimitingConsumerGroupInfoClient{
+		delegate:    delegate,
+		limiter:     limiter,
+		rateLimiter: rateLimiter,
+		concurrencyLimit: prometheus.NewDesc(
+			"kafka_consumer_group_exporter_describe_concurrency_limit",
+			"Current adaptive limit of concurrent consumer group queries.",
+			nil, nil),
+		concurrencyInUse: prometheus.NewDesc(
+			"kafka_consumer_group_exporter_describe_concurrency_in_use",
+			"Number of consumer group queries currently running.",
+			nil, nil),
+		rateLimitWait: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_rate_limit_wait_seconds_total",
+			Help: "Total time Kafka commands were delayed by the rate limit.",
+		}),
+		rateLimitDenied: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_rate_limit_denied_total",
+			Help: "Number of Kafka commands not run because the rate limit would have exceeded their timeout.",
+		}),
+	}
+}
+
+func (l *LimitingConsumerGroupInfoClient) waitForRate(ctx context.Context) error {
+	if l.rateLimiter == nil {
+		return nil
+	}
+	wait, err := l.rateLimiter.Wait(ctx)
+	l.rateLimitWait.Add(wait.Seconds())
+	if err == errRateLimitDeadline {
+		l.rateLimitDenied.Inc()
+	}
+	return err
+}
+
+// Groups calls Delegate.Groups() once the rate limit allows it.
+func (l *LimitingConsumerGroupInfoClient) Groups(ctx context.Context) ([]string, error) {
+	if err := l.waitForRate(ctx); err != nil {
+		return nil, err
+	}
+	return l.delegate.Groups(ctx)
+}
+
+// DescribeGroup calls Delegate.DescribeGroup() once both the concurrency limit
+// and the rate limit allow it. Its latency and error are fed back into the
+// concurrency limit.
+func (l *LimitingConsumerGroupInfoClient) DescribeGroup(ctx context.Context, group string) ([]exporter.PartitionInfo, error) {
+	var partitions []exporter.PartitionInfo
+	err := l.Do(ctx, func(ctx context.Context) error {
+		var err error
+		partitions, err = l.delegate.DescribeGroup(ctx, group)
+		return err
+	})
+	return partitions, err
+}
+
+// Do calls fn once both the concurrency limit and the rate limit allow it,
+// like DescribeGroup(). This lets Kafka commands not run through Delegate
+// share its limits.
+func (l *LimitingConsumerGroupInfoClient) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if l.limiter != nil {
+		if err := l.limiter.Acquire(ctx); err != nil {
+			return err
+		}
+	}
+	if err := l.waitForRate(ctx); err != nil {
+		if l.limiter != nil {
+			l.limiter.Abandon()
+		}
+		return err
+	}
+
+	start := time.Now()
+	err := fn(ctx)
+	if l.limiter != nil {
+		if ctx.Err() == context.Canceled {
+			// Commands killed on cancellation fail with their own error.
+			l.limiter.Release(time.Since(start), ctx.Err())
+		} else {
+			l.limiter.Release(time.Since(start), err)
+		}
+	}
+	return err
+}
+
+// Describe transmits all metric descriptions to ch.
+func (l *LimitingConsumerGroupInfoClient) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.concurrencyLimit
+	ch <- l.concurrencyInUse
+	l.rateLimitWait.Describe(ch)
+	l.rateLimitDenied.Describe(ch)
+}
+
+// Collect transmits the limiter metrics into ch.
+func (l *LimitingConsumerGroupInfoClient) Collect(ch chan<- prometheus.Metric) {
+	if l.limiter != nil {
+		ch <- prometheus.MustNewConstMetric(l.concurrencyLimit, prometheus.GaugeValue, float64(l.limiter.Limit()))
+		ch <- prometheus.MustNewConstMetric(l.concurrencyInUse, prometheus.GaugeValue, float64(l.limiter.InUse()))
+	}
+	l.rateLimitWait.Collect(ch)
+	l.rateLimitDenied.Collect(ch)
+}
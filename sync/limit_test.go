@@ -0,0 +1,165 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+)
+
+func TestAdaptiveLimiterAdjustsLimit(t *testing.T) {
+	limiter := NewAdaptiveLimiter(1, 8, time.Second)
+
+	for i := 0; i < 3; i++ {
+		limiter.Acquire(context.Background())
+		limiter.Release(time.Second, errors.New("failed"))
+	}
+	if limit := limiter.Limit(); limit != 3 {
+		t.Error("Expected limit to decrease to 3 after errors. Was:", limit)
+	}
+
+	for i := 0; i < 10; i++ {
+		limiter.Acquire(context.Background())
+		limiter.Release(time.Minute, nil)
+	}
+	if limit := limiter.Limit(); limit != 1 {
+		t.Error("Expected limit to decrease to the minimum after slow calls. Was:", limit)
+	}
+
+	for i := 0; i < 100; i++ {
+		limiter.Acquire(context.Background())
+		limiter.Release(time.Millisecond, nil)
+	}
+	if limit := limiter.Limit(); limit != 8 {
+		t.Error("Expected limit to increase to the maximum after fast calls. Was:", limit)
+	}
+}
+
+func TestLimitingClientKeepsLimitOnCancellation(t *testing.T) {
+	client := NewLimitingConsumerGroupInfoClient(nil, NewAdaptiveLimiter(1, 4, time.Second), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := client.Do(ctx, func(ctx context.Context) error {
+		cancel()
+		// Like a command killed on cancellation.
+		return errors.New("signal: killed")
+	})
+	if err == nil {
+		t.Fatal("Expected the call's error.")
+	}
+	if limit := client.limiter.Limit(); limit != 4 {
+		t.Error("Expected a cancelled call to not lower the limit. Was:", limit)
+	}
+	if inUse := client.limiter.InUse(); inUse != 0 {
+		t.Error("Expected the cancelled call to give its slot back. Slots in use:", inUse)
+	}
+
+	client.limiter.Acquire(context.Background())
+	client.limiter.Release(time.Minute, context.Canceled)
+	if limit := client.limiter.Limit(); limit != 4 {
+		t.Error("Expected context.Canceled to not lower the limit. Was:", limit)
+	}
+}
+
+func TestAdaptiveLimiterBlocksAtLimit(t *testing.T) {
+	limiter := NewAdaptiveLimiter(1, 1, time.Second)
+
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatal("Expected Acquire() to block until the deadline. Got:", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- limiter.Acquire(context.Background())
+	}()
+	waitFor(t, "waiter to queue up", func() bool {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return len(limiter.waiters) == 1
+	})
+	limiter.Release(time.Millisecond, nil)
+	if err := <-acquired; err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if inUse := limiter.InUse(); inUse != 1 {
+		t.Error("Expected a single slot in use. Was:", inUse)
+	}
+}
+
+func TestRateLimiterSpacesCalls(t *testing.T) {
+	clock := &fakeClock{time.Unix(0, 0)}
+	limiter := NewRateLimiter(2, 2)
+	limiter.now = clock.Now
+
+	var waits []time.Duration
+	for i := 0; i < 4; i++ {
+		wait, err := limiter.reserve(time.Time{}, false)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		waits = append(waits, wait)
+	}
+
+	expected := []time.Duration{0, 0, 500 * time.Millisecond, time.Second}
+	for i := range expected {
+		if waits[i] != expected[i] {
+			t.Error("Unexpected wait for call", i, "Expected:", expected[i], "Was:", waits[i])
+		}
+	}
+
+	clock.Advance(time.Minute)
+	if wait, _ := limiter.reserve(time.Time{}, false); wait != 0 {
+		t.Error("Expected no wait after being idle. Was:", wait)
+	}
+}
+
+func TestLimitingClientDeniesCallsExceedingDeadline(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	client := NewLimitingConsumerGroupInfoClient(delegate, NewAdaptiveLimiter(1, 4, time.Second), NewRateLimiter(0.1, 1))
+
+	if _, err := client.DescribeGroup(context.Background(), "default"); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.DescribeGroup(ctx, "default"); err != errRateLimitDeadline {
+		t.Error("Expected the rate limit to deny the call. Got:", err)
+	}
+
+	if delegate.DescribeGroupInvocations != 1 {
+		t.Error("Expected a single call to DescribeGroup(). It was called", delegate.DescribeGroupInvocations, "times.")
+	}
+	if inUse := client.limiter.InUse(); inUse != 0 {
+		t.Error("Expected denied call to give its slot back. Slots in use:", inUse)
+	}
+}
+
+func TestLimitingClientBelowFanInDeniesCallsExceedingDeadline(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	limitingClient := NewLimitingConsumerGroupInfoClient(delegate, nil, NewRateLimiter(0.1, 1))
+	// Wired like main does.
+	fanInClient := &FanInConsumerGroupInfoClient{Delegate: limitingClient}
+	defer fanInClient.Stop()
+
+	if _, err := fanInClient.DescribeGroup(context.Background(), "default"); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := fanInClient.DescribeGroup(ctx, "other"); err != errRateLimitDeadline {
+		t.Error("Expected the rate limit to deny the call right away. Got:", err)
+	}
+	if delegate.DescribeGroupInvocations != 1 {
+		t.Error("Expected a single call to DescribeGroup(). It was called", delegate.DescribeGroupInvocations, "times.")
+	}
+}
@@ -2,16 +2,32 @@ package sync
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
 	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
 )
 
+// waitFor polls cond until it returns true, failing the test if it takes too
+// long.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func TestFanOutGroupsListing(t *testing.T) {
+	release := make(chan struct{})
 	slowGroupLister := &mocks.ConsumerGroupsCommandClient{
 		GroupsFn: func() ([]string, error) {
+			<-release
 			return nil, nil
 		},
 	}
@@ -21,27 +37,33 @@ func TestFanOutGroupsListing(t *testing.T) {
 	}
 	defer fanOuter.Stop()
 
+	const callers = 10
 	var wg sync.WaitGroup
-	wg.Add(2)
-	f := func() {
-		fanOuter.Groups(context.Background())
-		wg.Done()
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			fanOuter.Groups(context.Background())
+		}()
 	}
 
-	go f()
-	go f()
+	waitFor(t, "all callers to subscribe", func() bool {
+		return fanOuter.groups.subscribers(groupsKey) == callers
+	})
+	close(release)
 	wg.Wait()
 
-	if slowGroupLister.GroupInvocations < 1 && slowGroupLister.GroupInvocations > 2 {
+	if slowGroupLister.GroupInvocations != 1 {
 		t.Error("Expected only a single invocation to Groups() function. It was called", slowGroupLister.GroupInvocations, "times.")
 	}
-
 }
 
 func TestFanOutDescribeGroup(t *testing.T) {
+	release := make(chan struct{})
 	slowGroupLister := &mocks.ConsumerGroupsCommandClient{
 		DescribeGroupFn: func(group string) ([]exporter.PartitionInfo, error) {
-			return nil, nil
+			<-release
+			return []exporter.PartitionInfo{{Topic: group}}, nil
 		},
 	}
 
@@ -50,19 +72,326 @@ func TestFanOutDescribeGroup(t *testing.T) {
 	}
 	defer fanOuter.Stop()
 
+	const callersPerGroup = 5
+	groups := []string{"a", "b", "c"}
 	var wg sync.WaitGroup
-	wg.Add(2)
-	f := func() {
-		fanOuter.DescribeGroup(context.Background(), "default")
-		wg.Done()
+	wg.Add(callersPerGroup * len(groups))
+	for _, group := range groups {
+		for i := 0; i < callersPerGroup; i++ {
+			go func(group string) {
+				defer wg.Done()
+				partitions, err := fanOuter.DescribeGroup(context.Background(), group)
+				if err != nil || len(partitions) != 1 || partitions[0].Topic != group {
+					t.Error("Unexpected result for group", group, ":", partitions, err)
+				}
+			}(group)
+		}
 	}
 
-	go f()
-	go f()
+	waitFor(t, "all callers to subscribe", func() bool {
+		for _, group := range groups {
+			if fanOuter.describes.subscribers(group) != callersPerGroup {
+				return false
+			}
+		}
+		return true
+	})
+	close(release)
 	wg.Wait()
 
-	if slowGroupLister.GroupInvocations < 1 && slowGroupLister.GroupInvocations > 2 {
-		t.Error("Expected only a single invocation to Groups() function. It was called", slowGroupLister.GroupInvocations, "times.")
+	if slowGroupLister.DescribeGroupInvocations != len(groups) {
+		t.Error("Expected a single invocation to DescribeGroup() per group. It was called", slowGroupLister.DescribeGroupInvocations, "times.")
+	}
+}
+
+func TestFanOutDescribeGroupEvictsIdleGroups(t *testing.T) {
+	fanOuter := &FanInConsumerGroupInfoClient{
+		Delegate: mocks.NewBasicConsumerGroupsCommandClient(),
+	}
+	defer fanOuter.Stop()
+
+	var wg sync.WaitGroup
+	wg.Add(100)
+	for i := 0; i < 100; i++ {
+		go func(i int) {
+			defer wg.Done()
+			fanOuter.DescribeGroup(context.Background(), fmt.Sprintf("ephemeral-%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	if n := fanOuter.describes.pending(); n != 0 {
+		t.Error("Expected no state to be kept for idle groups. Found", n, "groups.")
+	}
+}
+
+func TestFanOutStopWaitsForInFlightCalls(t *testing.T) {
+	release := make(chan struct{})
+	slowGroupLister := &mocks.ConsumerGroupsCommandClient{
+		DescribeGroupFn: func(group string) ([]exporter.PartitionInfo, error) {
+			<-release
+			return nil, nil
+		},
+	}
+
+	fanOuter := &FanInConsumerGroupInfoClient{
+		Delegate: slowGroupLister,
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			defer wg.Done()
+			fanOuter.DescribeGroup(context.Background(), "default")
+		}()
+	}
+	waitFor(t, "all callers to subscribe", func() bool {
+		return fanOuter.describes.subscribers("default") == 3
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		fanOuter.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop() returned while a call was in flight.")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	<-stopped
+	wg.Wait()
+
+	// The client must be reusable after Stop().
+	slowGroupLister.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		return nil, nil
+	}
+	if _, err := fanOuter.DescribeGroup(context.Background(), "default"); err != nil {
+		t.Error("Unexpected error after Stop():", err)
+	}
+	fanOuter.Stop()
+}
+
+// ctxClient is a exporter.ConsumerGroupInfoClient which blocks until either
+// release is closed or the context passed to it is done.
+type ctxClient struct {
+	release chan struct{}
+
+	mu        sync.Mutex
+	calls     int
+	cancelled int
+}
+
+func newCtxClient() *ctxClient {
+	return &ctxClient{release: make(chan struct{})}
+}
+
+func (c *ctxClient) Groups(ctx context.Context) ([]string, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+
+	select {
+	case <-c.release:
+		return []string{"default"}, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		c.cancelled++
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *ctxClient) DescribeGroup(ctx context.Context, group string) ([]exporter.PartitionInfo, error) {
+	groups, err := c.Groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []exporter.PartitionInfo{{Topic: groups[0]}}, nil
+}
+
+func (c *ctxClient) stats() (calls, cancelled int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls, c.cancelled
+}
+
+func TestFanOutSubscriberReturnsOnOwnCancellation(t *testing.T) {
+	delegate := newCtxClient()
+	fanOuter := &FanInConsumerGroupInfoClient{
+		Delegate: delegate,
+	}
+	defer fanOuter.Stop()
+
+	patientResult := make(chan error)
+	go func() {
+		_, err := fanOuter.DescribeGroup(context.Background(), "default")
+		patientResult <- err
+	}()
+	waitFor(t, "first caller to subscribe", func() bool {
+		return fanOuter.describes.subscribers("default") == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := fanOuter.DescribeGroup(ctx, "default"); err != context.DeadlineExceeded {
+		t.Error("Expected the impatient caller to time out. Got:", err)
+	}
+
+	// The remaining subscriber must still get the result.
+	close(delegate.release)
+	if err := <-patientResult; err != nil {
+		t.Error("Unexpected error for the remaining caller:", err)
+	}
+	if calls, cancelled := delegate.stats(); calls != 1 || cancelled != 0 {
+		t.Error("Expected a single uncancelled call. Got", calls, "calls and", cancelled, "cancellations.")
+	}
+}
+
+func TestFanOutCancelsSharedCallWhenAllSubscribersAreGone(t *testing.T) {
+	delegate := newCtxClient()
+	fanOuter := &FanInConsumerGroupInfoClient{
+		Delegate: delegate,
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	results := make(chan error, 2)
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		go func(ctx context.Context) {
+			_, err := fanOuter.Groups(ctx)
+			results <- err
+		}(ctx)
+	}
+	waitFor(t, "both callers to subscribe", func() bool {
+		return fanOuter.groups.subscribers(groupsKey) == 2
+	})
+
+	cancel1()
+	if err := <-results; err != context.Canceled {
+		t.Error("Expected the first caller to be cancelled. Got:", err)
+	}
+	if _, cancelled := delegate.stats(); cancelled != 0 {
+		t.Error("Expected the shared call to keep running for the second caller.")
+	}
+	cancel2()
+	if err := <-results; err != context.Canceled {
+		t.Error("Expected the second caller to be cancelled. Got:", err)
+	}
+
+	// Stop() waits for the shared call to return, which it only does if it was
+	// cancelled.
+	fanOuter.Stop()
+	if calls, cancelled := delegate.stats(); calls != 1 || cancelled != 1 {
+		t.Error("Expected a single cancelled call. Got", calls, "calls and", cancelled, "cancellations.")
+	}
+
+	// New callers must not be handed the result of the cancelled call.
+	close(delegate.release)
+	if _, err := fanOuter.Groups(context.Background()); err != nil {
+		t.Error("Unexpected error after previous call was cancelled:", err)
 	}
+}
 
+// deadlineClient is a exporter.ConsumerGroupInfoClient which hands the context
+// of every call to calls, and blocks until release is closed.
+type deadlineClient struct {
+	calls   chan context.Context
+	release chan struct{}
+}
+
+func (c *deadlineClient) Groups(ctx context.Context) ([]string, error) {
+	c.calls <- ctx
+	<-c.release
+	return nil, ctx.Err()
+}
+
+func (c *deadlineClient) DescribeGroup(ctx context.Context, group string) ([]exporter.PartitionInfo, error) {
+	_, err := c.Groups(ctx)
+	return nil, err
+}
+
+func TestFanOutPassesLatestDeadlineToSharedCall(t *testing.T) {
+	delegate := &deadlineClient{calls: make(chan context.Context, 1), release: make(chan struct{})}
+	fanOuter := &FanInConsumerGroupInfoClient{
+		Delegate: delegate,
+	}
+	defer fanOuter.Stop()
+
+	first := time.Now().Add(time.Hour)
+	ctx1, cancel1 := context.WithDeadline(context.Background(), first)
+	defer cancel1()
+	go fanOuter.DescribeGroup(ctx1, "default")
+	callCtx := <-delegate.calls
+	if deadline, ok := callCtx.Deadline(); !ok || !deadline.Equal(first) {
+		t.Error("Expected the deadline of the first caller. Got:", deadline, ok)
+	}
+
+	second := first.Add(time.Hour)
+	ctx2, cancel2 := context.WithDeadline(context.Background(), second)
+	defer cancel2()
+	go fanOuter.DescribeGroup(ctx2, "default")
+	waitFor(t, "second caller to subscribe", func() bool {
+		return fanOuter.describes.subscribers("default") == 2
+	})
+	if deadline, ok := callCtx.Deadline(); !ok || !deadline.Equal(second) {
+		t.Error("Expected the deadline to be pushed back to the one of the second caller. Got:", deadline, ok)
+	}
+
+	ctx3, cancel3 := context.WithDeadline(context.Background(), first)
+	defer cancel3()
+	go fanOuter.DescribeGroup(ctx3, "default")
+	waitFor(t, "third caller to subscribe", func() bool {
+		return fanOuter.describes.subscribers("default") == 3
+	})
+	if deadline, ok := callCtx.Deadline(); !ok || !deadline.Equal(second) {
+		t.Error("Expected an earlier deadline to be ignored. Got:", deadline, ok)
+	}
+
+	go fanOuter.DescribeGroup(context.Background(), "default")
+	waitFor(t, "fourth caller to subscribe", func() bool {
+		return fanOuter.describes.subscribers("default") == 4
+	})
+	if deadline, ok := callCtx.Deadline(); ok {
+		t.Error("Expected a caller without deadline to remove it. Got:", deadline)
+	}
+	close(delegate.release)
+}
+
+func TestFanOutOldestCallIncludesAbandonedCalls(t *testing.T) {
+	release := make(chan struct{})
+	stuck := &mocks.ConsumerGroupsCommandClient{
+		DescribeGroupFn: func(group string) ([]exporter.PartitionInfo, error) {
+			<-release
+			return nil, nil
+		},
+	}
+	fanOuter := &FanInConsumerGroupInfoClient{
+		Delegate: stuck,
+	}
+	if !fanOuter.OldestCall().IsZero() {
+		t.Error("Expected no call in flight.")
+	}
+
+	before := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fanOuter.DescribeGroup(ctx, "default")
+
+	// The call ignores its cancellation, so it is still running.
+	if oldest := fanOuter.OldestCall(); oldest.Before(before) || oldest.After(time.Now()) {
+		t.Error("Expected the abandoned call to be in flight. Oldest call:", oldest)
+	}
+	close(release)
+	fanOuter.Stop()
+	if !fanOuter.OldestCall().IsZero() {
+		t.Error("Expected no call in flight after Stop().")
+	}
 }
@@ -0,0 +1,150 @@
+package sync
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryingConsumerGroupInfoClient is a exporter.ConsumerGroupInfoClient
+// decorator that retries calls failing with a *exporter.RetryableError. The
+// delay before each retry is picked at random up to an exponentially growing
+// backoff. A call is not retried if the delay would go past the deadline of
+// its context.
+type RetryingConsumerGroupInfoClient struct {
+	delegate   exporter.ConsumerGroupInfoClient
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	describeRetries *prometheus.CounterVec
+	listRetries     prometheus.Counter
+
+	// retried holds the groups with a retry counter, so that the counters
+	// of groups that no longer exist can be deleted.
+	mu      sync.Mutex
+	retried map[string]bool
+
+	// jitter returns a random duration in [0, d). Overridden in tests.
+	jitter func(d time.Duration) time.Duration
+}
+
+// NewRetryingConsumerGroupInfoClient returns a retrying decorator of delegate.
+// Each call is retried at most maxRetries times. The backoff starts at backoff
+// and doubles for every retry, but never exceeds maxBackoff.
+func NewRetryingConsumerGroupInfoClient(delegate exporter.ConsumerGroupInfoClient, maxRetries int, backoff, maxBackoff time.Duration) *RetryingConsumerGroupInfoClient {
+	return &RetryingConsumerGroupInfoClient{
+		delegate:   delegate,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		describeRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_describe_retries_total",
+			Help: "Number of retried consumer group describes due to transient errors.",
+		}, []string{"group"}),
+		listRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kafka_consumer_group_exporter_list_retries_total",
+			Help: "Number of retried consumer group listings due to transient errors.",
+		}),
+		jitter: func(d time.Duration) time.Duration {
+			if d <= 0 {
+				return 0
+			}
+			return time.Duration(rand.Int63n(int64(d)))
+		},
+		retried: make(map[string]bool),
+	}
+}
+
+// Groups calls Delegate.Groups(), retrying transient errors. Retry counters
+// of groups that no longer exist are deleted.
+func (r *RetryingConsumerGroupInfoClient) Groups(ctx context.Context) ([]string, error) {
+	var groups []string
+	err := r.retry(ctx, func() { r.listRetries.Inc() }, log.Fields{}, func() (err error) {
+		groups, err = r.delegate.Groups(ctx)
+		return
+	})
+	if err != nil {
+		return groups, err
+	}
+
+	exists := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		exists[group] = true
+	}
+	r.mu.Lock()
+	for group := range r.retried {
+		if !exists[group] {
+			delete(r.retried, group)
+			r.describeRetries.DeleteLabelValues(group)
+		}
+	}
+	r.mu.Unlock()
+
+	return groups, nil
+}
+
+// DescribeGroup calls Delegate.DescribeGroup(), retrying transient errors.
+func (r *RetryingConsumerGroupInfoClient) DescribeGroup(ctx context.Context, group string) ([]exporter.PartitionInfo, error) {
+	var partitions []exporter.PartitionInfo
+	retried := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.retried[group] = true
+		r.describeRetries.WithLabelValues(group).Inc()
+	}
+	err := r.retry(ctx, retried, log.Fields{"group": group}, func() (err error) {
+		partitions, err = r.delegate.DescribeGroup(ctx, group)
+		return
+	})
+	return partitions, err
+}
+
+// retry calls call until it succeeds, fails with a non-retryable error or runs
+// out of retries or time. retried is called before every retry.
+func (r *RetryingConsumerGroupInfoClient) retry(ctx context.Context, retried func(), fields log.Fields, call func() error) error {
+	backoff := r.backoff
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if _, retryable := err.(*exporter.RetryableError); !retryable || attempt >= r.maxRetries {
+			return err
+		}
+
+		delay := r.jitter(backoff)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		log.WithFields(fields).WithField("delay", delay).WithError(err).Info("Retrying after transient error")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		retried()
+
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+// Describe transmits all metric descriptions to ch.
+func (r *RetryingConsumerGroupInfoClient) Describe(ch chan<- *prometheus.Desc) {
+	r.describeRetries.Describe(ch)
+	r.listRetries.Describe(ch)
+}
+
+// Collect transmits the retry metrics into ch.
+func (r *RetryingConsumerGroupInfoClient) Collect(ch chan<- prometheus.Metric) {
+	r.describeRetries.Collect(ch)
+	r.listRetries.Collect(ch)
+}
@@ -0,0 +1,163 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newTestRetryingClient(delegate exporter.ConsumerGroupInfoClient) (*RetryingConsumerGroupInfoClient, *[]time.Duration) {
+	client := NewRetryingConsumerGroupInfoClient(delegate, 3, time.Millisecond, 3*time.Millisecond)
+	var backoffs []time.Duration
+	client.jitter = func(d time.Duration) time.Duration {
+		backoffs = append(backoffs, d)
+		return d
+	}
+	return client, &backoffs
+}
+
+func TestRetryingClientRetriesTransientErrors(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	succeed := delegate.DescribeGroupFn
+	failures := 2
+	delegate.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		if failures > 0 {
+			failures--
+			return nil, &exporter.RetryableError{Err: errors.New("group is rebalancing")}
+		}
+		return succeed(group)
+	}
+	client, backoffs := newTestRetryingClient(delegate)
+
+	partitions, err := client.DescribeGroup(context.Background(), "default")
+	if err != nil || len(partitions) != 1 {
+		t.Fatal("Expected the retry to succeed. Got:", partitions, err)
+	}
+	if delegate.DescribeGroupInvocations != 3 {
+		t.Error("Expected 3 calls to DescribeGroup(). It was called", delegate.DescribeGroupInvocations, "times.")
+	}
+	if len(*backoffs) != 2 || (*backoffs)[0] != time.Millisecond || (*backoffs)[1] != 2*time.Millisecond {
+		t.Error("Unexpected backoffs:", *backoffs)
+	}
+
+	var m dto.Metric
+	client.describeRetries.WithLabelValues("default").Write(&m)
+	if m.Counter.GetValue() != 2 {
+		t.Error("Expected 2 retries to be counted. Was:", m.Counter.GetValue())
+	}
+}
+
+func TestRetryingClientDeletesCountersOfRemovedGroups(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	succeed := delegate.DescribeGroupFn
+	failures := 1
+	delegate.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		if failures > 0 {
+			failures--
+			return nil, &exporter.RetryableError{Err: errors.New("group is rebalancing")}
+		}
+		return succeed(group)
+	}
+	client, _ := newTestRetryingClient(delegate)
+
+	if _, err := client.DescribeGroup(context.Background(), "default"); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if count := testutil.CollectAndCount(client.describeRetries); count != 1 {
+		t.Fatal("Expected a retry counter for the group. Found", count)
+	}
+
+	if _, err := client.Groups(context.Background()); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if count := testutil.CollectAndCount(client.describeRetries); count != 1 {
+		t.Error("Expected the retry counter of a listed group to be kept. Found", count)
+	}
+
+	delegate.GroupsFn = func() ([]string, error) {
+		return []string{"other"}, nil
+	}
+	if _, err := client.Groups(context.Background()); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if count := testutil.CollectAndCount(client.describeRetries); count != 0 {
+		t.Error("Expected the retry counter of the removed group to be deleted. Found", count)
+	}
+}
+
+func TestRetryingClientGivesUp(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	delegate.GroupsFn = func() ([]string, error) {
+		return nil, &exporter.RetryableError{Err: errors.New("coordinator not available")}
+	}
+	client, backoffs := newTestRetryingClient(delegate)
+
+	if _, err := client.Groups(context.Background()); err == nil {
+		t.Fatal("Expected an error.")
+	}
+	if delegate.GroupInvocations != 4 {
+		t.Error("Expected 4 calls to Groups(). It was called", delegate.GroupInvocations, "times.")
+	}
+	if (*backoffs)[2] != 3*time.Millisecond {
+		t.Error("Expected the backoff to be capped. Backoffs:", *backoffs)
+	}
+}
+
+func TestRetryingClientDoesNotRetryPermanentErrors(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	delegate.GroupsFn = func() ([]string, error) {
+		return nil, errors.New("no such group")
+	}
+	client, _ := newTestRetryingClient(delegate)
+
+	client.Groups(context.Background())
+	if delegate.GroupInvocations != 1 {
+		t.Error("Expected a single call to Groups(). It was called", delegate.GroupInvocations, "times.")
+	}
+}
+
+func TestRetryingClientRespectsDeadline(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	delegate.GroupsFn = func() ([]string, error) {
+		return nil, &exporter.RetryableError{Err: errors.New("group is rebalancing")}
+	}
+	client := NewRetryingConsumerGroupInfoClient(delegate, 3, time.Minute, time.Minute)
+	client.jitter = func(d time.Duration) time.Duration { return d }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.Groups(ctx); err == nil {
+		t.Fatal("Expected an error.")
+	}
+	if delegate.GroupInvocations != 1 {
+		t.Error("Expected no retry past the deadline. Groups() was called", delegate.GroupInvocations, "times.")
+	}
+}
+
+func TestRetryingClientBelowFanInRespectsCallerDeadline(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	delegate.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		return nil, &exporter.RetryableError{Err: errors.New("group is rebalancing")}
+	}
+	retryingClient := NewRetryingConsumerGroupInfoClient(delegate, 3, time.Minute, time.Minute)
+	retryingClient.jitter = func(d time.Duration) time.Duration { return d }
+	// Wired like main does.
+	fanInClient := &FanInConsumerGroupInfoClient{Delegate: retryingClient}
+	defer fanInClient.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := fanInClient.DescribeGroup(ctx, "default")
+	if _, retryable := err.(*exporter.RetryableError); !retryable {
+		t.Fatal("Expected the transient error to be returned right away. Got:", err)
+	}
+	if delegate.DescribeGroupInvocations != 1 {
+		t.Error("Expected no retry past the deadline. DescribeGroup() was called", delegate.DescribeGroupInvocations, "times.")
+	}
+}
@@ -0,0 +1,190 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GroupSchedule decides how often, and in which order, the consumer groups
+// whose names match Pattern are described.
+type GroupSchedule struct {
+	// Pattern must match the whole group name.
+	Pattern *regexp.Regexp
+	// Interval is the minimum time between two describes of a group. Zero
+	// means that the group is described every time it is asked for.
+	Interval time.Duration
+	// Priority orders groups. Groups with higher priority are listed, and
+	// hence described, first.
+	Priority int
+}
+
+// ParseGroupSchedule parses a GroupSchedule from the format
+// `INTERVAL:PRIORITY:REGEX`, for example `15s:10:payments-.*`. The regular
+// expression is last so it can contain colons.
+func ParseGroupSchedule(s string) (GroupSchedule, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return GroupSchedule{}, fmt.Errorf("group schedule '%s' is not on the format INTERVAL:PRIORITY:REGEX", s)
+	}
+	interval, err := time.ParseDuration(parts[0])
+	if err != nil {
+		return GroupSchedule{}, fmt.Errorf("invalid interval in group schedule '%s': %s", s, err)
+	}
+	priority, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return GroupSchedule{}, fmt.Errorf("invalid priority in group schedule '%s': %s", s, err)
+	}
+	pattern, err := regexp.Compile("^(?:" + parts[2] + ")$")
+	if err != nil {
+		return GroupSchedule{}, fmt.Errorf("invalid regexp in group schedule '%s': %s", s, err)
+	}
+	return GroupSchedule{pattern, interval, priority}, nil
+}
+
+// SchedulingConsumerGroupInfoClient is a exporter.ConsumerGroupInfoClient
+// decorator that describes each consumer group according to the first
+// GroupSchedule matching it. Groups not matching any schedule are described
+// every time with priority zero.
+//
+// Groups() returns groups in priority order, so that callers describing groups
+// in order describe high priority groups first. DescribeGroup() returns the
+// last result for a group until the interval of its schedule has passed.
+type SchedulingConsumerGroupInfoClient struct {
+	delegate  exporter.ConsumerGroupInfoClient
+	schedules []GroupSchedule
+
+	dataAge *prometheus.Desc
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	results map[string]scheduledResult
+}
+
+type scheduledResult struct {
+	partitions []exporter.PartitionInfo
+	// fetched is when the oldest of the partitions was fetched from Kafka,
+	// which is earlier than when they were described if the delegate serves
+	// them from a cache.
+	fetched time.Time
+}
+
+// oldestFetchedAt returns when the oldest of partitions was fetched from
+// Kafka, or now if that is not known.
+func oldestFetchedAt(partitions []exporter.PartitionInfo, now time.Time) time.Time {
+	var oldest time.Time
+	for _, part := range partitions {
+		if !part.FetchedAt.IsZero() && (oldest.IsZero() || part.FetchedAt.Before(oldest)) {
+			oldest = part.FetchedAt
+		}
+	}
+	if oldest.IsZero() {
+		return now
+	}
+	return oldest
+}
+
+// NewSchedulingConsumerGroupInfoClient returns a scheduling decorator of
+// delegate.
+func NewSchedulingConsumerGroupInfoClient(delegate exporter.ConsumerGroupInfoClient, schedules []GroupSchedule) *SchedulingConsumerGroupInfoClient {
+	return &SchedulingConsumerGroupInfoClient{
+		delegate:  delegate,
+		schedules: schedules,
+		dataAge: prometheus.NewDesc(
+			"kafka_consumer_group_exporter_group_data_age_seconds",
+			"Time since the data exported for a consumer group was fetched from Kafka.",
+			[]string{"group"}, nil),
+		now:     time.Now,
+		results: make(map[string]scheduledResult),
+	}
+}
+
+func (s *SchedulingConsumerGroupInfoClient) schedule(group string) GroupSchedule {
+	for _, schedule := range s.schedules {
+		if schedule.Pattern.MatchString(group) {
+			return schedule
+		}
+	}
+	return GroupSchedule{}
+}
+
+// Groups returns the groups from Delegate.Groups(), highest priority first.
+// Groups with the same priority keep the order returned by Delegate.
+func (s *SchedulingConsumerGroupInfoClient) Groups(ctx context.Context) ([]string, error) {
+	groups, err := s.delegate.Groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	priorities := make(map[string]int, len(groups))
+	for _, group := range groups {
+		priorities[group] = s.schedule(group).Priority
+	}
+	sorted := make([]string, len(groups))
+	copy(sorted, groups)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return priorities[sorted[i]] > priorities[sorted[j]]
+	})
+
+	// Forget groups that no longer exist.
+	s.mu.Lock()
+	for group := range s.results {
+		if _, ok := priorities[group]; !ok {
+			delete(s.results, group)
+		}
+	}
+	s.mu.Unlock()
+
+	return sorted, nil
+}
+
+// DescribeGroup returns the last result of Delegate.DescribeGroup() for group
+// if it is younger than the interval of the group's schedule. Otherwise it
+// calls Delegate.
+func (s *SchedulingConsumerGroupInfoClient) DescribeGroup(ctx context.Context, group string) ([]exporter.PartitionInfo, error) {
+	interval := s.schedule(group).Interval
+
+	s.mu.Lock()
+	result, ok := s.results[group]
+	s.mu.Unlock()
+	if ok && s.now().Sub(result.fetched) < interval {
+		return result.partitions, nil
+	}
+
+	partitions, err := s.delegate.DescribeGroup(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.results[group] = scheduledResult{partitions, oldestFetchedAt(partitions, s.now())}
+	s.mu.Unlock()
+
+	return partitions, nil
+}
+
+// Describe transmits all metric descriptions to ch.
+func (s *SchedulingConsumerGroupInfoClient) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.dataAge
+}
+
+// Collect transmits the age of each group's data into ch.
+func (s *SchedulingConsumerGroupInfoClient) Collect(ch chan<- prometheus.Metric) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for group, result := range s.results {
+		ch <- prometheus.MustNewConstMetric(s.dataAge, prometheus.GaugeValue, now.Sub(result.fetched).Seconds(), group)
+	}
+}
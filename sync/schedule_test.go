@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func mustParseGroupSchedules(t *testing.T, specs ...string) []GroupSchedule {
+	var schedules []GroupSchedule
+	for _, spec := range specs {
+		schedule, err := ParseGroupSchedule(spec)
+		if err != nil {
+			t.Fatal("Could not parse group schedule:", err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules
+}
+
+func TestParseGroupSchedule(t *testing.T) {
+	schedule, err := ParseGroupSchedule("15s:10:payments:.*")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if schedule.Interval != 15*time.Second || schedule.Priority != 10 {
+		t.Error("Unexpected schedule:", schedule)
+	}
+	if !schedule.Pattern.MatchString("payments:eu") || schedule.Pattern.MatchString("old-payments:eu") {
+		t.Error("Pattern must match the whole group name:", schedule.Pattern)
+	}
+
+	for _, invalid := range []string{"15s", "15:10:.*", "15s:high:.*", "15s:10:("} {
+		if _, err := ParseGroupSchedule(invalid); err == nil {
+			t.Error("Expected an error for", invalid)
+		}
+	}
+}
+
+func TestSchedulingClientOrdersGroupsByPriority(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	delegate.GroupsFn = func() ([]string, error) {
+		return []string{"batch-1", "critical", "other", "batch-2"}, nil
+	}
+	client := NewSchedulingConsumerGroupInfoClient(delegate, mustParseGroupSchedules(t,
+		"15s:10:critical",
+		"5m:-1:batch-.*",
+	))
+
+	groups, err := client.Groups(context.Background())
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := []string{"critical", "other", "batch-1", "batch-2"}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Error("Unexpected group order. Expected:", expected, "Was:", groups)
+	}
+}
+
+func TestSchedulingClientHonoursIntervals(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	clock := &fakeClock{time.Unix(0, 0)}
+	client := NewSchedulingConsumerGroupInfoClient(delegate, mustParseGroupSchedules(t, "5m:0:batch"))
+	client.now = clock.Now
+
+	describe := func(group string) {
+		if _, err := client.DescribeGroup(context.Background(), group); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	describe("batch")
+	describe("critical")
+	clock.Advance(time.Minute)
+	describe("batch")
+	describe("critical")
+	if delegate.DescribeGroupInvocations != 3 {
+		t.Error("Expected 3 calls to DescribeGroup(). It was called", delegate.DescribeGroupInvocations, "times.")
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	client.Collect(ch)
+	close(ch)
+	ages := make(map[string]float64)
+	for metric := range ch {
+		var m dto.Metric
+		metric.Write(&m)
+		ages[m.Label[0].GetValue()] = m.Gauge.GetValue()
+	}
+	if ages["batch"] != 60 || ages["critical"] != 0 {
+		t.Error("Unexpected data ages:", ages)
+	}
+
+	clock.Advance(5 * time.Minute)
+	describe("batch")
+	if delegate.DescribeGroupInvocations != 4 {
+		t.Error("Expected group to be described again after its interval. DescribeGroup() was called", delegate.DescribeGroupInvocations, "times.")
+	}
+}
+
+func TestSchedulingClientAgesDataByFetchTime(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	clock := &fakeClock{time.Unix(600, 0)}
+	fetchedAt := []time.Time{time.Unix(540, 0), time.Unix(480, 0), {}}
+	delegate.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		// Served from a cache below, so fetched before they are described.
+		partitions := make([]exporter.PartitionInfo, len(fetchedAt))
+		for i := range partitions {
+			partitions[i].FetchedAt = fetchedAt[i]
+		}
+		return partitions, nil
+	}
+	client := NewSchedulingConsumerGroupInfoClient(delegate, mustParseGroupSchedules(t, "3m:0:batch"))
+	client.now = clock.Now
+
+	describe := func() {
+		if _, err := client.DescribeGroup(context.Background(), "batch"); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	describe()
+	ch := make(chan prometheus.Metric, 1)
+	client.Collect(ch)
+	var m dto.Metric
+	(<-ch).Write(&m)
+	if m.Gauge.GetValue() != 120 {
+		t.Error("Expected the data to be as old as the oldest partition. Was:", m.Gauge.GetValue())
+	}
+
+	// Three minutes after the oldest partition was fetched, not after it was
+	// described.
+	clock.Advance(time.Minute)
+	describe()
+	if delegate.DescribeGroupInvocations != 2 {
+		t.Error("Expected the group to be described again after its interval. DescribeGroup() was called", delegate.DescribeGroupInvocations, "times.")
+	}
+
+	// Falls back to now if no fetch time is known.
+	fetchedAt = []time.Time{{}}
+	clock.Advance(3 * time.Minute)
+	describe()
+	clock.Advance(time.Minute)
+	describe()
+	if delegate.DescribeGroupInvocations != 3 {
+		t.Error("Expected the result to be kept for its interval. DescribeGroup() was called", delegate.DescribeGroupInvocations, "times.")
+	}
+}
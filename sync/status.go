@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+)
+
+// RecordingConsumerGroupInfoClient is a exporter.ConsumerGroupInfoClient
+// decorator that remembers the outcome of the latest calls, so that it can be
+// shown to humans.
+type RecordingConsumerGroupInfoClient struct {
+	delegate exporter.ConsumerGroupInfoClient
+
+	// now is overridden in tests.
+	now func() time.Time
+
+	mu     sync.Mutex
+	status RecordedStatus
+	groups map[string]*GroupStatus
+}
+
+// RecordedStatus is the outcome of the latest calls through a
+// RecordingConsumerGroupInfoClient.
+type RecordedStatus struct {
+	// LastList is when groups were last listed successfully.
+	LastList time.Time
+	// ListError is the error of the last listing, if it failed.
+	ListError string
+	// Groups holds the currently listed groups, sorted by descending total
+	// lag.
+	Groups []GroupStatus
+	// LastError is the latest error of any call. LastErrorGroup is empty if
+	// it happened while listing groups.
+	LastError      string
+	LastErrorGroup string
+	LastErrorTime  time.Time
+}
+
+// GroupStatus is the outcome of the latest describes of a group.
+type GroupStatus struct {
+	Group string
+	// Partitions and TotalLag are from the last describe returning any
+	// partitions, which may be stale.
+	Partitions []exporter.PartitionInfo
+	TotalLag   int64
+	// LastSuccess is when the partitions were described.
+	LastSuccess time.Time
+	// Errors counts all failed describes since the group was first listed.
+	Errors        int
+	LastError     string
+	LastErrorTime time.Time
+}
+
+// NewRecordingConsumerGroupInfoClient returns a recording decorator of
+// delegate.
+func NewRecordingConsumerGroupInfoClient(delegate exporter.ConsumerGroupInfoClient) *RecordingConsumerGroupInfoClient {
+	return &RecordingConsumerGroupInfoClient{
+		delegate: delegate,
+		now:      time.Now,
+		groups:   make(map[string]*GroupStatus),
+	}
+}
+
+// Groups calls Delegate.Groups() and records the outcome. Groups which are no
+// longer listed are forgotten.
+func (r *RecordingConsumerGroupInfoClient) Groups(ctx context.Context) ([]string, error) {
+	groups, err := r.delegate.Groups(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if err != nil {
+		r.status.ListError = err.Error()
+		r.recordError("", err, now)
+		return groups, err
+	}
+	r.status.LastList = now
+	r.status.ListError = ""
+
+	listed := make(map[string]*GroupStatus, len(groups))
+	for _, group := range groups {
+		status, ok := r.groups[group]
+		if !ok {
+			status = &GroupStatus{Group: group}
+		}
+		listed[group] = status
+	}
+	r.groups = listed
+	return groups, nil
+}
+
+// DescribeGroup calls Delegate.DescribeGroup() and records the outcome.
+func (r *RecordingConsumerGroupInfoClient) DescribeGroup(ctx context.Context, group string) ([]exporter.PartitionInfo, error) {
+	partitions, err := r.delegate.DescribeGroup(ctx, group)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	status, ok := r.groups[group]
+	if !ok {
+		// Not listed yet, or asked for directly.
+		status = &GroupStatus{Group: group}
+		r.groups[group] = status
+	}
+	stale, isStale := err.(*exporter.StaleError)
+	if err == nil || isStale {
+		status.Partitions = partitions
+		status.TotalLag = 0
+		for _, part := range partitions {
+			status.TotalLag += part.Lag
+		}
+		status.LastSuccess = now
+		if isStale {
+			status.LastSuccess = stale.LastSuccess
+		}
+	}
+	if err != nil {
+		status.Errors++
+		status.LastError = err.Error()
+		status.LastErrorTime = now
+		r.recordError(group, err, now)
+	}
+	return partitions, err
+}
+
+func (r *RecordingConsumerGroupInfoClient) recordError(group string, err error, now time.Time) {
+	r.status.LastError = err.Error()
+	r.status.LastErrorGroup = group
+	r.status.LastErrorTime = now
+}
+
+// Status returns the recorded status.
+func (r *RecordingConsumerGroupInfoClient) Status() RecordedStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := r.status
+	status.Groups = make([]GroupStatus, 0, len(r.groups))
+	for _, group := range r.groups {
+		status.Groups = append(status.Groups, *group)
+	}
+	sort.Slice(status.Groups, func(i, j int) bool {
+		a, b := status.Groups[i], status.Groups[j]
+		if a.TotalLag != b.TotalLag {
+			return a.TotalLag > b.TotalLag
+		}
+		return a.Group < b.Group
+	})
+	return status
+}
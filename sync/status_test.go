@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+)
+
+func TestRecordingClient(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	delegate.GroupsFn = func() ([]string, error) {
+		return []string{"default", "broken"}, nil
+	}
+	describe := delegate.DescribeGroupFn
+	delegate.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		if group == "broken" {
+			return nil, errors.New("could not parse output")
+		}
+		return describe(group)
+	}
+	clock := &fakeClock{time.Unix(1000, 0)}
+	client := NewRecordingConsumerGroupInfoClient(delegate)
+	client.now = clock.Now
+
+	client.Groups(context.Background())
+	client.DescribeGroup(context.Background(), "default")
+	clock.Advance(time.Second)
+	client.DescribeGroup(context.Background(), "broken")
+	client.DescribeGroup(context.Background(), "broken")
+
+	status := client.Status()
+	if status.LastList != time.Unix(1000, 0) || status.ListError != "" {
+		t.Error("Unexpected listing status:", status.LastList, status.ListError)
+	}
+	if len(status.Groups) != 2 {
+		t.Fatal("Expected 2 groups. Got:", status.Groups)
+	}
+	if g := status.Groups[0]; g.Group != "default" || g.TotalLag != 99 || g.Errors != 0 || g.LastSuccess != time.Unix(1000, 0) {
+		t.Error("Unexpected status of the default group:", g)
+	}
+	if g := status.Groups[1]; g.Group != "broken" || g.Errors != 2 || g.LastError != "could not parse output" || !g.LastSuccess.IsZero() {
+		t.Error("Unexpected status of the broken group:", g)
+	}
+	if status.LastError != "could not parse output" || status.LastErrorGroup != "broken" || status.LastErrorTime != time.Unix(1001, 0) {
+		t.Error("Unexpected last error:", status.LastError, status.LastErrorGroup, status.LastErrorTime)
+	}
+}
+
+func TestRecordingClientForgetsUnlistedGroups(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	client := NewRecordingConsumerGroupInfoClient(delegate)
+
+	client.Groups(context.Background())
+	client.DescribeGroup(context.Background(), "default")
+	delegate.GroupsFn = func() ([]string, error) {
+		return nil, nil
+	}
+	client.Groups(context.Background())
+
+	if groups := client.Status().Groups; len(groups) != 0 {
+		t.Error("Expected the unlisted group to be forgotten. Got:", groups)
+	}
+
+	delegate.GroupsFn = func() ([]string, error) {
+		return nil, errors.New("timed out")
+	}
+	client.Groups(context.Background())
+	if status := client.Status(); status.ListError != "timed out" || status.LastErrorGroup != "" {
+		t.Error("Expected the listing error to be recorded. Got:", status)
+	}
+}
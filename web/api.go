@@ -0,0 +1,293 @@
+// Package web serves the HTTP endpoints of the exporter other than metrics.
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+	log "github.com/sirupsen/logrus"
+)
+
+// APIPrefix is the path the API is served under.
+const APIPrefix = "/api/v1/"
+
+// API serves the latest results of the collector as JSON:
+//
+//	GET /api/v1/groups            lists all consumer groups with their total lag
+//	GET /api/v1/groups/{group}    returns the partitions of a consumer group
+//
+// Both accept the following query parameters:
+//
+//	sort=lag|name    what to sort by, "lag" by default
+//	order=desc|asc   sort order, descending by lag and ascending by name by default
+//	min_lag=N        only return entries with at least this lag
+//	limit=N          return at most N entries
+//
+// The group list also accepts group=REGEX, and the partitions topic=REGEX, to
+// only return matching groups or topics. Regular expressions must match the
+// whole name.
+//
+// Kafka is never queried on requests, so groups are only known once they have
+// been scraped.
+type API struct {
+	source StatusSource
+}
+
+// NewAPI returns an API serving the results recorded by source.
+func NewAPI(source StatusSource) *API {
+	return &API{source: source}
+}
+
+type apiResponse struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type apiGroup struct {
+	Group      string    `json:"group"`
+	TotalLag   int64     `json:"total_lag"`
+	Partitions int       `json:"partitions"`
+	Time       time.Time `json:"time"`
+}
+
+type apiGroupDetails struct {
+	Group      string         `json:"group"`
+	TotalLag   int64          `json:"total_lag"`
+	Time       time.Time      `json:"time"`
+	Partitions []apiPartition `json:"partitions"`
+}
+
+type apiPartition struct {
+	Topic           string `json:"topic"`
+	Partition       string `json:"partition"`
+	CurrentOffset   int64  `json:"current_offset"`
+	Lag             int64  `json:"lag"`
+	ClientID        string `json:"client_id"`
+	ConsumerAddress string `json:"consumer_address"`
+}
+
+func newAPIPartition(part exporter.PartitionInfo) apiPartition {
+	return apiPartition{
+		Topic:           part.Topic,
+		Partition:       part.PartitionID,
+		CurrentOffset:   part.CurrentOffset,
+		Lag:             part.Lag,
+		ClientID:        part.ClientID,
+		ConsumerAddress: part.ConsumerAddress,
+	}
+}
+
+// listQuery holds the parsed query parameters shared by all endpoints.
+type listQuery struct {
+	filter   *regexp.Regexp
+	sortName bool
+	asc      bool
+	minLag   int64
+	limit    int
+}
+
+func parseListQuery(r *http.Request, filterParam string) (listQuery, error) {
+	values := r.URL.Query()
+	var q listQuery
+	if filter := values.Get(filterParam); filter != "" {
+		re, err := regexp.Compile("^(?:" + filter + ")$")
+		if err != nil {
+			return q, fmt.Errorf("invalid %s: %s", filterParam, err)
+		}
+		q.filter = re
+	}
+	switch values.Get("sort") {
+	case "", "lag":
+	case "name":
+		q.sortName = true
+		q.asc = true
+	default:
+		return q, fmt.Errorf("invalid sort %q, must be \"lag\" or \"name\"", values.Get("sort"))
+	}
+	switch values.Get("order") {
+	case "":
+	case "asc":
+		q.asc = true
+	case "desc":
+		q.asc = false
+	default:
+		return q, fmt.Errorf("invalid order %q, must be \"asc\" or \"desc\"", values.Get("order"))
+	}
+	if v := values.Get("min_lag"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid min_lag %q", v)
+		}
+		q.minLag = n
+	}
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+		q.limit = n
+	}
+	return q, nil
+}
+
+// less compares two entries according to q. nameLess and nameEqual tell how
+// the names of the two entries compare.
+func (q listQuery) less(nameLess, nameEqual bool, lagI, lagJ int64) bool {
+	if !q.sortName && lagI != lagJ {
+		if q.asc {
+			return lagI < lagJ
+		}
+		return lagI > lagJ
+	}
+	if q.asc {
+		return nameLess
+	}
+	return !nameLess && !nameEqual
+}
+
+// limited returns the number of entries to return out of n.
+func (q listQuery) limited(n int) int {
+	if q.limit > 0 && q.limit < n {
+		return q.limit
+	}
+	return n
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, APIPrefix)
+	switch {
+	case path == "groups":
+		a.serveGroups(w, r)
+	case strings.HasPrefix(path, "groups/") && len(path) > len("groups/"):
+		a.serveGroup(w, r, strings.TrimPrefix(path, "groups/"))
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (a *API) serveGroups(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r, "group")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := a.source.Status()
+	if status.LastList.IsZero() {
+		writeAPIError(w, http.StatusServiceUnavailable, "groups have not been listed yet")
+		return
+	}
+
+	groups := []apiGroup{}
+	for _, recorded := range status.Groups {
+		if recorded.LastSuccess.IsZero() || q.filter != nil && !q.filter.MatchString(recorded.Group) {
+			continue
+		}
+		group := apiGroup{
+			Group:      recorded.Group,
+			TotalLag:   recorded.TotalLag,
+			Partitions: len(recorded.Partitions),
+			Time:       recorded.LastSuccess,
+		}
+		if group.TotalLag < q.minLag {
+			continue
+		}
+		groups = append(groups, group)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return q.less(groups[i].Group < groups[j].Group, groups[i].Group == groups[j].Group, groups[i].TotalLag, groups[j].TotalLag)
+	})
+	writeAPIData(w, groups[:q.limited(len(groups))])
+}
+
+func (a *API) serveGroup(w http.ResponseWriter, r *http.Request, group string) {
+	q, err := parseListQuery(r, "topic")
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	recorded, ok := findGroup(a.source.Status().Groups, group)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("no such group %q", group))
+		return
+	}
+	if recorded.LastSuccess.IsZero() {
+		if recorded.LastError != "" {
+			writeAPIError(w, http.StatusBadGateway, "could not describe group: "+recorded.LastError)
+		} else {
+			writeAPIError(w, http.StatusServiceUnavailable, "group has not been described yet")
+		}
+		return
+	}
+
+	details := apiGroupDetails{
+		Group:      group,
+		TotalLag:   recorded.TotalLag,
+		Time:       recorded.LastSuccess,
+		Partitions: []apiPartition{},
+	}
+	for _, part := range recorded.Partitions {
+		if q.filter != nil && !q.filter.MatchString(part.Topic) || part.Lag < q.minLag {
+			continue
+		}
+		details.Partitions = append(details.Partitions, newAPIPartition(part))
+	}
+	parts := details.Partitions
+	sort.SliceStable(parts, func(i, j int) bool {
+		nameLess, nameEqual := partitionLess(parts[i], parts[j])
+		return q.less(nameLess, nameEqual, parts[i].Lag, parts[j].Lag)
+	})
+	details.Partitions = parts[:q.limited(len(parts))]
+	writeAPIData(w, details)
+}
+
+// partitionLess orders partitions by topic, then numerically by partition.
+func partitionLess(a, b apiPartition) (less, equal bool) {
+	if a.Topic != b.Topic {
+		return a.Topic < b.Topic, false
+	}
+	idA, errA := strconv.Atoi(a.Partition)
+	idB, errB := strconv.Atoi(b.Partition)
+	if errA != nil || errB != nil {
+		return a.Partition < b.Partition, a.Partition == b.Partition
+	}
+	return idA < idB, idA == idB
+}
+
+func findGroup(groups []sync.GroupStatus, group string) (sync.GroupStatus, bool) {
+	for _, g := range groups {
+		if g.Group == group {
+			return g, true
+		}
+	}
+	return sync.GroupStatus{}, false
+}
+
+func writeAPIData(w http.ResponseWriter, data interface{}) {
+	writeAPIResponse(w, http.StatusOK, apiResponse{Status: "success", Data: data})
+}
+
+func writeAPIError(w http.ResponseWriter, code int, msg string) {
+	writeAPIResponse(w, code, apiResponse{Status: "error", Error: msg})
+}
+
+func writeAPIResponse(w http.ResponseWriter, code int, resp apiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.WithError(err).Warn("Could not write API response")
+	}
+}
@@ -0,0 +1,154 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+)
+
+// newTestClient returns a client with the groups "small", "large" and
+// "broken", the latter failing to be described.
+func newTestClient() *mocks.ConsumerGroupsCommandClient {
+	client := mocks.NewBasicConsumerGroupsCommandClient()
+	client.GroupsFn = func() ([]string, error) {
+		return []string{"small", "large", "broken"}, nil
+	}
+	client.DescribeGroupFn = func(group string) ([]exporter.PartitionInfo, error) {
+		switch group {
+		case "small":
+			return []exporter.PartitionInfo{
+				{Topic: "events", PartitionID: "0", CurrentOffset: 10, Lag: 1, ClientID: "consumer-1", ConsumerAddress: "10.0.0.1"},
+			}, nil
+		case "large":
+			return []exporter.PartitionInfo{
+				{Topic: "events", PartitionID: "10", CurrentOffset: 10, Lag: 50, ClientID: "consumer-2", ConsumerAddress: "10.0.0.2"},
+				{Topic: "events", PartitionID: "2", CurrentOffset: 10, Lag: 100, ClientID: "consumer-2", ConsumerAddress: "10.0.0.2"},
+				{Topic: "audit", PartitionID: "0", CurrentOffset: 10, Lag: 0, ClientID: "-", ConsumerAddress: "-"},
+			}, nil
+		}
+		return nil, errors.New("timed out")
+	}
+	return client
+}
+
+// scrape lists and describes all groups through client, like the collector.
+func scrape(client *sync.RecordingConsumerGroupInfoClient) {
+	groups, _ := client.Groups(context.Background())
+	for _, group := range groups {
+		client.DescribeGroup(context.Background(), group)
+	}
+}
+
+// newTestSource returns the results of scraping newTestClient().
+func newTestSource() *sync.RecordingConsumerGroupInfoClient {
+	source := sync.NewRecordingConsumerGroupInfoClient(newTestClient())
+	scrape(source)
+	return source
+}
+
+// get requests url from handler and decodes the response into data.
+func get(t *testing.T, handler http.Handler, url string, data interface{}) (int, string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	resp := struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+		Error  string          `json:"error"`
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal("Could not decode response:", err)
+	}
+	if resp.Status == "success" {
+		if err := json.Unmarshal(resp.Data, data); err != nil {
+			t.Fatal("Could not decode data:", err)
+		}
+	}
+	return w.Code, resp.Error
+}
+
+func TestAPIGroups(t *testing.T) {
+	api := NewAPI(newTestSource())
+
+	var groups []apiGroup
+	if code, msg := get(t, api, "/api/v1/groups", &groups); code != http.StatusOK {
+		t.Fatal("Unexpected status:", code, msg)
+	}
+	if len(groups) != 2 || groups[0].Group != "large" || groups[0].TotalLag != 150 || groups[0].Partitions != 3 || groups[1].Group != "small" {
+		t.Error("Expected the described groups sorted by lag. Got:", groups)
+	}
+
+	get(t, api, "/api/v1/groups?sort=name&order=desc", &groups)
+	if len(groups) != 2 || groups[0].Group != "small" {
+		t.Error("Expected the groups sorted by descending name. Got:", groups)
+	}
+
+	get(t, api, "/api/v1/groups?group=l.*", &groups)
+	if len(groups) != 1 || groups[0].Group != "large" {
+		t.Error("Expected only the large group. Got:", groups)
+	}
+
+	get(t, api, "/api/v1/groups?min_lag=2", &groups)
+	if len(groups) != 1 || groups[0].Group != "large" {
+		t.Error("Expected only the large group. Got:", groups)
+	}
+
+	if code, _ := get(t, api, "/api/v1/groups?sort=owner", &groups); code != http.StatusBadRequest {
+		t.Error("Expected an invalid sort to be rejected. Status:", code)
+	}
+}
+
+func TestAPIGroup(t *testing.T) {
+	api := NewAPI(newTestSource())
+
+	var group apiGroupDetails
+	if code, msg := get(t, api, "/api/v1/groups/large?sort=name", &group); code != http.StatusOK {
+		t.Fatal("Unexpected status:", code, msg)
+	}
+	if group.TotalLag != 150 || len(group.Partitions) != 3 {
+		t.Fatal("Unexpected group:", group)
+	}
+	if p := group.Partitions; p[0].Topic != "audit" || p[1].Partition != "2" || p[2].Partition != "10" || p[2].ClientID != "consumer-2" {
+		t.Error("Expected partitions sorted by topic and partition. Got:", p)
+	}
+
+	get(t, api, "/api/v1/groups/large?topic=events&limit=1", &group)
+	if len(group.Partitions) != 1 || group.Partitions[0].Lag != 100 {
+		t.Error("Expected the events partition with the highest lag. Got:", group.Partitions)
+	}
+
+	if code, _ := get(t, api, "/api/v1/groups/missing", &group); code != http.StatusNotFound {
+		t.Error("Expected an unknown group to be not found. Status:", code)
+	}
+	if code, _ := get(t, api, "/api/v1/groups/broken", &group); code != http.StatusBadGateway {
+		t.Error("Expected a failing describe to be a bad gateway. Status:", code)
+	}
+}
+
+func TestAPIServesRecordedResults(t *testing.T) {
+	client := newTestClient()
+	source := sync.NewRecordingConsumerGroupInfoClient(client)
+	api := NewAPI(source)
+
+	var groups []apiGroup
+	if code, _ := get(t, api, "/api/v1/groups", &groups); code != http.StatusServiceUnavailable {
+		t.Error("Expected the groups to be unavailable before the first scrape. Status:", code)
+	}
+
+	scrape(source)
+	get(t, api, "/api/v1/groups", &groups)
+	var group apiGroupDetails
+	get(t, api, "/api/v1/groups/large", &group)
+	if len(groups) != 2 || group.TotalLag != 150 {
+		t.Error("Expected the scraped results. Got:", groups, group)
+	}
+	if client.GroupInvocations != 1 || client.DescribeGroupInvocations != 3 {
+		t.Error("Expected requests to not query Kafka. Groups:", client.GroupInvocations, "Describes:", client.DescribeGroupInvocations)
+	}
+}
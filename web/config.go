@@ -0,0 +1,264 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Config configures TLS and authentication of the HTTP server. It is read
+// from the same YAML format as the web configuration of Prometheus exporters:
+//
+//	tls_server_config:
+//	  cert_file: server.crt
+//	  key_file: server.key
+//	  # One of NoClientCert (default), RequestClientCert,
+//	  # RequireAnyClientCert, VerifyClientCertIfGiven and
+//	  # RequireAndVerifyClientCert.
+//	  client_auth_type: RequireAndVerifyClientCert
+//	  client_ca_file: ca.crt
+//	basic_auth_users:
+//	  alice: $2y$10$...  # bcrypt hash of the password
+type Config struct {
+	TLS   TLSConfig         `yaml:"tls_server_config"`
+	Users map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSConfig configures TLS. TLS is disabled unless CertFile is set.
+type TLSConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// LoadConfig reads and validates a Config from path.
+func LoadConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("invalid web config %s: %s", path, err)
+	}
+
+	tlsConfig := config.TLS
+	if tlsConfig.CertFile == "" && (tlsConfig.KeyFile != "" || tlsConfig.ClientAuthType != "" || tlsConfig.ClientCAFile != "") {
+		return nil, fmt.Errorf("invalid web config %s: cert_file is required to configure TLS", path)
+	}
+	if tlsConfig.CertFile != "" && tlsConfig.KeyFile == "" {
+		return nil, fmt.Errorf("invalid web config %s: key_file is required with cert_file", path)
+	}
+	if _, ok := clientAuthTypes[tlsConfig.ClientAuthType]; !ok {
+		return nil, fmt.Errorf("invalid web config %s: unknown client_auth_type %q", path, tlsConfig.ClientAuthType)
+	}
+	for user, hash := range config.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid web config %s: password of user %q is not a bcrypt hash: %s", path, user, err)
+		}
+	}
+	return &config, nil
+}
+
+// highestCost returns the highest cost of the bcrypt hashes of users, or
+// bcrypt.DefaultCost if there are none.
+func highestCost(users map[string]string) int {
+	highest := 0
+	for _, hash := range users {
+		if cost, err := bcrypt.Cost([]byte(hash)); err == nil && cost > highest {
+			highest = cost
+		}
+	}
+	if highest == 0 {
+		return bcrypt.DefaultCost
+	}
+	return highest
+}
+
+// NewServer returns an http.Server listening on addr and serving handler
+// according to config, which may be nil. Requests must authenticate as one of
+// the configured users, except for the health probes. The server must be
+// started with Serve.
+func NewServer(addr string, handler http.Handler, config *Config, readTimeout, writeTimeout, idleTimeout time.Duration) (*http.Server, error) {
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+	if config == nil {
+		return server, nil
+	}
+
+	if len(config.Users) > 0 {
+		// Unknown users are compared against a hash of the highest configured
+		// cost, so that they take as long to reject as wrong passwords.
+		dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy"), highestCost(config.Users))
+		if err != nil {
+			return nil, err
+		}
+		server.Handler = &basicAuthHandler{
+			users:     config.Users,
+			handler:   handler,
+			dummyHash: string(dummyHash),
+			verified:  make(map[[sha256.Size]byte]bool),
+		}
+	}
+	if config.TLS.CertFile != "" {
+		reloader := &certReloader{certFile: config.TLS.CertFile, keyFile: config.TLS.KeyFile}
+		if _, err := reloader.getCertificate(nil); err != nil {
+			return nil, err
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.getCertificate,
+			ClientAuth:     clientAuthTypes[config.TLS.ClientAuthType],
+		}
+		if config.TLS.ClientCAFile != "" {
+			pem, err := ioutil.ReadFile(config.TLS.ClientCAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", config.TLS.ClientCAFile)
+			}
+			server.TLSConfig.ClientCAs = pool
+		}
+	}
+	return server, nil
+}
+
+// Serve starts server, using TLS if it is configured. It always returns a
+// non-nil error, http.ErrServerClosed after the server is shut down.
+func Serve(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// certReloader loads a certificate, and loads it again whenever the files
+// change on disk. If loading fails, it keeps using the previous certificate.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu                sync.Mutex
+	cert              *tls.Certificate
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certInfo, certErr := os.Stat(r.certFile)
+	keyInfo, keyErr := os.Stat(r.keyFile)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if certErr != nil || keyErr != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		if certErr != nil {
+			return nil, certErr
+		}
+		return nil, keyErr
+	}
+	if r.cert != nil &&
+		certInfo.ModTime().Equal(r.certMod) && certInfo.Size() == r.certSize &&
+		keyInfo.ModTime().Equal(r.keyMod) && keyInfo.Size() == r.keySize {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			log.WithError(err).WithField("file", r.certFile).Warn("Could not reload TLS certificate, keeping the previous one")
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	if r.cert != nil {
+		log.WithField("file", r.certFile).Info("Reloaded TLS certificate")
+	}
+	r.cert = &cert
+	r.certMod, r.certSize = certInfo.ModTime(), certInfo.Size()
+	r.keyMod, r.keySize = keyInfo.ModTime(), keyInfo.Size()
+	return r.cert, nil
+}
+
+// basicAuthHandler requires requests to authenticate as one of users, except
+// for the health probes. Since bcrypt is slow by design, successfully verified
+// credentials are remembered.
+type basicAuthHandler struct {
+	users     map[string]string
+	handler   http.Handler
+	dummyHash string
+
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]bool
+}
+
+// maxVerifiedCredentials bounds the memory used to remember verified
+// credentials.
+const maxVerifiedCredentials = 1000
+
+func (b *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == HealthyPath || r.URL.Path == ReadyPath || b.authenticated(r) {
+		b.handler.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="kafka-consumer-group-exporter"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (b *basicAuthHandler) authenticated(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, ok := b.users[user]
+	if !ok {
+		hash = b.dummyHash
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	b.mu.Lock()
+	verified := b.verified[key]
+	b.mu.Unlock()
+	if verified {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || !ok {
+		return false
+	}
+	b.mu.Lock()
+	if len(b.verified) >= maxVerifiedCredentials {
+		b.verified = make(map[[sha256.Size]byte]bool)
+	}
+	b.verified[key] = true
+	b.mu.Unlock()
+	return true
+}
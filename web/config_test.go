@@ -0,0 +1,236 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "web-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeCertificate writes a self-signed certificate for commonName to
+// certFile and its key to keyFile.
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeFile(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "web.yml")
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, path, `
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+basic_auth_users:
+  alice: `+string(hash)+`
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if config.TLS.CertFile != "server.crt" || config.TLS.ClientAuthType != "RequireAndVerifyClientCert" || config.Users["alice"] != string(hash) {
+		t.Error("Unexpected config:", config)
+	}
+
+	for _, invalid := range []string{
+		"unknown_field: true",
+		"tls_server_config:\n  key_file: server.key",
+		"tls_server_config:\n  cert_file: server.crt",
+		"tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: Sometimes",
+		"basic_auth_users:\n  alice: secret",
+	} {
+		writeFile(t, path, invalid)
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("Expected %q to be rejected.", invalid)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	server, err := NewServer(":0", ok, &Config{Users: map[string]string{"alice": string(hash)}}, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		path, user, password string
+		code                 int
+	}{
+		{"/metrics", "alice", "secret", http.StatusOK},
+		{"/metrics", "alice", "secret", http.StatusOK},
+		{"/metrics", "alice", "wrong", http.StatusUnauthorized},
+		{"/metrics", "bob", "secret", http.StatusUnauthorized},
+		{"/metrics", "", "", http.StatusUnauthorized},
+		{HealthyPath, "", "", http.StatusOK},
+		{ReadyPath, "", "", http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.user != "" {
+			r.SetBasicAuth(c.user, c.password)
+		}
+		w := httptest.NewRecorder()
+		server.Handler.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("Expected %d for %s as %q:%q. Got: %d", c.code, c.path, c.user, c.password, w.Code)
+		}
+	}
+
+	// Unknown users take as long to reject as users of the configured cost.
+	dummyHash := server.Handler.(*basicAuthHandler).dummyHash
+	if cost, err := bcrypt.Cost([]byte(dummyHash)); err != nil || cost != bcrypt.MinCost {
+		t.Error("Expected the dummy hash to have the configured cost. Was:", cost, err)
+	}
+}
+
+func TestHighestCost(t *testing.T) {
+	low, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost+2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost := highestCost(map[string]string{"alice": string(low), "bob": string(high)}); cost != bcrypt.MinCost+2 {
+		t.Error("Expected the highest configured cost. Was:", cost)
+	}
+	if cost := highestCost(nil); cost != bcrypt.DefaultCost {
+		t.Error("Expected the default cost without users. Was:", cost)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := tempDir(t)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	if _, err := reloader.getCertificate(nil); err == nil {
+		t.Error("Expected an error without certificate.")
+	}
+
+	writeCertificate(t, certFile, keyFile, "first")
+	cert, err := reloader.getCertificate(nil)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if name := commonName(t, cert); name != "first" {
+		t.Error("Unexpected certificate:", name)
+	}
+
+	writeCertificate(t, certFile, keyFile, "second")
+	if cert, err = reloader.getCertificate(nil); err != nil || commonName(t, cert) != "second" {
+		t.Error("Expected the changed certificate to be reloaded. Error:", err)
+	}
+
+	writeFile(t, keyFile, "garbage")
+	if cert, err = reloader.getCertificate(nil); err != nil || commonName(t, cert) != "second" {
+		t.Error("Expected a broken certificate to be ignored. Error:", err)
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := tempDir(t)
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeCertificate(t, certFile, keyFile, "exporter")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	config := &Config{TLS: TLSConfig{CertFile: certFile, KeyFile: keyFile}}
+	server, err := NewServer(listener.Addr().String(), handler, config, time.Second, time.Second, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	pem, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	resp, err := client.Get("https://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "hello" {
+		t.Error("Unexpected body:", string(body))
+	}
+}
@@ -0,0 +1,140 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/kafka"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+)
+
+// DebugDescribePath is the path the DebugHandler is served under.
+const DebugDescribePath = "/debug/describe"
+
+// DebugDescriber describes consumer groups, returning everything seen on the
+// way.
+type DebugDescriber interface {
+	DebugDescribeGroup(ctx context.Context, group string) kafka.DescribeGroupDebugInfo
+}
+
+// limitedDebugDescriber is a DebugDescriber sharing the limits of the other
+// Kafka commands.
+type limitedDebugDescriber struct {
+	describer DebugDescriber
+	limiter   *sync.LimitingConsumerGroupInfoClient
+}
+
+// NewLimitedDebugDescriber returns a DebugDescriber describing through
+// describer once limiter allows it, so that debug describes count against the
+// same concurrency and rate limits as all other Kafka commands.
+func NewLimitedDebugDescriber(describer DebugDescriber, limiter *sync.LimitingConsumerGroupInfoClient) DebugDescriber {
+	return &limitedDebugDescriber{describer, limiter}
+}
+
+func (l *limitedDebugDescriber) DebugDescribeGroup(ctx context.Context, group string) kafka.DescribeGroupDebugInfo {
+	var info kafka.DescribeGroupDebugInfo
+	err := l.limiter.Do(ctx, func(ctx context.Context) error {
+		info = l.describer.DebugDescribeGroup(ctx, group)
+		return info.Err
+	})
+	if info.Err == nil {
+		// Denied by the limiter.
+		info.Err = err
+	}
+	return info
+}
+
+// DebugHandler describes the consumer group given by the group query
+// parameter right away, bypassing any caching, and returns the raw command
+// output, the parser that parsed it and the parsed partitions as JSON.
+type DebugHandler struct {
+	describer DebugDescriber
+	timeout   time.Duration
+	slots     chan struct{}
+	username  string
+	password  string
+}
+
+// NewDebugHandler returns a DebugHandler describing through describer. Each
+// describe must complete within timeout, and requests beyond
+// maxConcurrentQueries concurrent ones are rejected. If username is
+// non-empty, requests must authenticate with it and password using basic
+// authentication. It returns an error if only one of username and password is
+// given, since an empty password would let anyone knowing the username in.
+func NewDebugHandler(describer DebugDescriber, timeout time.Duration, maxConcurrentQueries int, username, password string) (*DebugHandler, error) {
+	if username != "" && password == "" {
+		return nil, errors.New("a password is required along with the username")
+	}
+	if username == "" && password != "" {
+		return nil, errors.New("a username is required along with the password")
+	}
+	return &DebugHandler{
+		describer: describer,
+		timeout:   timeout,
+		slots:     make(chan struct{}, maxConcurrentQueries),
+		username:  username,
+		password:  password,
+	}, nil
+}
+
+type debugDescription struct {
+	Group      string         `json:"group"`
+	Stdout     string         `json:"stdout"`
+	Stderr     string         `json:"stderr"`
+	Parser     string         `json:"parser"`
+	Partitions []apiPartition `json:"partitions"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func (d *DebugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.username != "" && !d.authenticated(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="debug"`)
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	group := r.URL.Query().Get("group")
+	if group == "" {
+		writeAPIError(w, http.StatusBadRequest, "missing group")
+		return
+	}
+
+	select {
+	case d.slots <- struct{}{}:
+		defer func() { <-d.slots }()
+	default:
+		writeAPIError(w, http.StatusTooManyRequests, "too many concurrent describes")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), d.timeout)
+	info := d.describer.DebugDescribeGroup(ctx, group)
+	cancel()
+
+	description := debugDescription{
+		Group:      group,
+		Stdout:     info.Output.Stdout,
+		Stderr:     info.Output.Stderr,
+		Parser:     info.Parser,
+		Partitions: []apiPartition{},
+	}
+	for _, part := range info.Partitions {
+		description.Partitions = append(description.Partitions, newAPIPartition(part))
+	}
+	if info.Err != nil {
+		description.Error = info.Err.Error()
+	}
+	writeAPIData(w, description)
+}
+
+func (d *DebugHandler) authenticated(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	usernameOk := subtle.ConstantTimeCompare([]byte(username), []byte(d.username)) == 1
+	passwordOk := subtle.ConstantTimeCompare([]byte(password), []byte(d.password)) == 1
+	return usernameOk && passwordOk
+}
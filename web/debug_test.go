@@ -0,0 +1,139 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	exporter "github.com/kawamuray/prometheus-kafka-consumer-group-exporter"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/kafka"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+)
+
+// newTestDebugHandler returns a handler like NewDebugHandler, failing the test
+// if it cannot be created.
+func newTestDebugHandler(t *testing.T, describer DebugDescriber, maxConcurrentQueries int, username, password string) *DebugHandler {
+	handler, err := NewDebugHandler(describer, time.Minute, maxConcurrentQueries, username, password)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return handler
+}
+
+type debugDescriberFunc func(ctx context.Context, group string) kafka.DescribeGroupDebugInfo
+
+func (f debugDescriberFunc) DebugDescribeGroup(ctx context.Context, group string) kafka.DescribeGroupDebugInfo {
+	return f(ctx, group)
+}
+
+func TestDebugHandler(t *testing.T) {
+	handler := newTestDebugHandler(t, debugDescriberFunc(func(ctx context.Context, group string) kafka.DescribeGroupDebugInfo {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("Expected the describe to have a deadline.")
+		}
+		return kafka.DescribeGroupDebugInfo{
+			Output:     kafka.CommandOutput{Stdout: "TOPIC PARTITION", Stderr: "Note"},
+			Parser:     "test",
+			Partitions: []exporter.PartitionInfo{{Topic: "events", PartitionID: "0", Lag: 5}},
+			Err:        errors.New("partially parsed"),
+		}
+	}), 1, "", "")
+
+	var description debugDescription
+	if code, msg := get(t, handler, "/debug/describe?group=default", &description); code != http.StatusOK {
+		t.Fatal("Unexpected status:", code, msg)
+	}
+	if description.Group != "default" || description.Stdout != "TOPIC PARTITION" || description.Stderr != "Note" || description.Parser != "test" {
+		t.Error("Unexpected description:", description)
+	}
+	if len(description.Partitions) != 1 || description.Partitions[0].Lag != 5 || description.Error != "partially parsed" {
+		t.Error("Unexpected partitions or error:", description)
+	}
+
+	if code, _ := get(t, handler, "/debug/describe", &description); code != http.StatusBadRequest {
+		t.Error("Expected a missing group to be rejected. Status:", code)
+	}
+}
+
+func TestDebugHandlerLimitsConcurrency(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := newTestDebugHandler(t, debugDescriberFunc(func(ctx context.Context, group string) kafka.DescribeGroupDebugInfo {
+		close(started)
+		<-release
+		return kafka.DescribeGroupDebugInfo{}
+	}), 1, "", "")
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/debug/describe?group=slow", nil))
+		close(done)
+	}()
+	<-started
+
+	var description debugDescription
+	if code, _ := get(t, handler, "/debug/describe?group=other", &description); code != http.StatusTooManyRequests {
+		t.Error("Expected a concurrent describe to be rejected. Status:", code)
+	}
+	close(release)
+	<-done
+}
+
+func TestLimitedDebugDescriberSharesRateLimit(t *testing.T) {
+	limiter := sync.NewLimitingConsumerGroupInfoClient(mocks.NewBasicConsumerGroupsCommandClient(), nil, sync.NewRateLimiter(0.1, 1))
+	described := false
+	describer := NewLimitedDebugDescriber(debugDescriberFunc(func(ctx context.Context, group string) kafka.DescribeGroupDebugInfo {
+		described = true
+		return kafka.DescribeGroupDebugInfo{}
+	}), limiter)
+
+	if _, err := limiter.DescribeGroup(context.Background(), "default"); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if info := describer.DebugDescribeGroup(ctx, "default"); info.Err == nil || described {
+		t.Error("Expected the debug describe to be denied by the rate limit. Got:", info)
+	}
+}
+
+func TestDebugHandlerAuthentication(t *testing.T) {
+	handler := newTestDebugHandler(t, debugDescriberFunc(func(ctx context.Context, group string) kafka.DescribeGroupDebugInfo {
+		return kafka.DescribeGroupDebugInfo{}
+	}), 1, "oncall", "secret")
+
+	for _, test := range []struct {
+		username, password string
+		expected           int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"oncall", "wrong", http.StatusUnauthorized},
+		{"oncall", "secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/debug/describe?group=default", nil)
+		if test.username != "" {
+			req.SetBasicAuth(test.username, test.password)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != test.expected {
+			t.Error("Unexpected status for", test.username, test.password, ":", w.Code)
+		}
+	}
+}
+
+func TestNewDebugHandlerRequiresBothCredentials(t *testing.T) {
+	describer := debugDescriberFunc(func(ctx context.Context, group string) kafka.DescribeGroupDebugInfo {
+		return kafka.DescribeGroupDebugInfo{}
+	})
+	if _, err := NewDebugHandler(describer, time.Minute, 1, "oncall", ""); err == nil {
+		t.Error("Expected an empty password to be rejected.")
+	}
+	if _, err := NewDebugHandler(describer, time.Minute, 1, "", "secret"); err == nil {
+		t.Error("Expected a password without username to be rejected.")
+	}
+}
@@ -0,0 +1,125 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+	log "github.com/sirupsen/logrus"
+)
+
+// Paths of the liveness and readiness probes.
+const (
+	HealthyPath = "/-/healthy"
+	ReadyPath   = "/-/ready"
+)
+
+// Check returns an error if something is wrong.
+type Check func(ctx context.Context) error
+
+// Probe runs a number of checks for every request, and answers 200 if all
+// pass and 503 otherwise. It is cheap enough to be probed frequently, unlike
+// the metrics endpoint.
+type Probe struct {
+	timeout time.Duration
+	names   []string
+	checks  []Check
+}
+
+// NewProbe returns a Probe without checks. All checks together must complete
+// within timeout.
+func NewProbe(timeout time.Duration) *Probe {
+	return &Probe{timeout: timeout}
+}
+
+// AddCheck adds a check, identified by name in failure messages. It must not
+// be called once the Probe is served.
+func (p *Probe) AddCheck(name string, check Check) {
+	p.names = append(p.names, name)
+	p.checks = append(p.checks, check)
+}
+
+func (p *Probe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), p.timeout)
+	defer cancel()
+
+	var failures []string
+	for i, check := range p.checks {
+		if err := check(ctx); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", p.names[i], err))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(failures, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// ListedWithinCheck returns a Check failing unless groups were listed
+// successfully within window, according to the status recorded by source. It
+// never queries Kafka itself, so probing the exporter puts no load on Kafka.
+// Run KeepListed along with it, so that an exporter that is not scraped yet,
+// such as one that is not ready, can become ready.
+func ListedWithinCheck(source StatusSource, window time.Duration) Check {
+	return func(context.Context) error {
+		status := source.Status()
+		if !status.LastList.IsZero() && time.Since(status.LastList) <= window {
+			return nil
+		}
+		msg := "groups were never listed"
+		if !status.LastList.IsZero() {
+			msg = fmt.Sprintf("groups were last listed at %s, more than %s ago", status.LastList.Format(time.RFC3339), window)
+		}
+		if status.ListError != "" {
+			msg += ": " + status.ListError
+		}
+		return errors.New(msg)
+	}
+}
+
+// KeepListed lists groups through client right away, and then whenever they
+// have not been listed within interval, until ctx is done. Scrapes listing
+// groups often enough make it list nothing. Each listing must complete within
+// timeout.
+func KeepListed(ctx context.Context, client *sync.RecordingConsumerGroupInfoClient, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		if time.Since(client.Status().LastList) >= interval {
+			listCtx, cancel := context.WithTimeout(ctx, timeout)
+			if _, err := client.Groups(listCtx); err != nil {
+				log.WithError(err).Warn("Could not list groups")
+			}
+			cancel()
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// InFlightCheck returns a Check failing if oldest returns a time more than
+// max ago. oldest returns when the longest running call started, or the zero
+// time if there is none.
+func InFlightCheck(oldest func() time.Time, max time.Duration) Check {
+	return func(context.Context) error {
+		started := oldest()
+		if started.IsZero() {
+			return nil
+		}
+		if running := time.Since(started); running > max {
+			return fmt.Errorf("a call has been running for %s, it is likely deadlocked", running.Truncate(time.Second))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/mocks"
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+)
+
+func probe(p *Probe) (int, string) {
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", ReadyPath, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestProbe(t *testing.T) {
+	p := NewProbe(time.Second)
+	p.AddCheck("fine", func(context.Context) error { return nil })
+	if code, body := probe(p); code != http.StatusOK || body != "OK\n" {
+		t.Error("Expected the probe to pass. Got:", code, body)
+	}
+
+	p.AddCheck("broken", func(context.Context) error { return errors.New("it broke") })
+	if code, body := probe(p); code != http.StatusServiceUnavailable || !strings.Contains(body, "broken: it broke") {
+		t.Error("Expected the probe to fail. Got:", code, body)
+	}
+}
+
+func TestListedWithinCheck(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	client := sync.NewRecordingConsumerGroupInfoClient(delegate)
+	check := ListedWithinCheck(client, time.Minute)
+
+	if err := check(context.Background()); err == nil || !strings.Contains(err.Error(), "never listed") {
+		t.Error("Expected the check to fail when groups were never listed. Error:", err)
+	}
+	if delegate.GroupInvocations != 0 {
+		t.Error("Expected the check to not list groups itself. Listings:", delegate.GroupInvocations)
+	}
+
+	client.Groups(context.Background())
+	if err := check(context.Background()); err != nil {
+		t.Error("Expected a recent listing to be enough. Error:", err)
+	}
+
+	delegate.GroupsFn = func() ([]string, error) {
+		return nil, errors.New("timed out")
+	}
+	client.Groups(context.Background())
+	err := ListedWithinCheck(client, 0)(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "last listed at") || !strings.Contains(err.Error(), "timed out") {
+		t.Error("Expected a stale listing to fail the check with the listing error. Error:", err)
+	}
+	if delegate.GroupInvocations != 2 {
+		t.Error("Expected the check to not list groups itself. Listings:", delegate.GroupInvocations)
+	}
+}
+
+func TestKeepListed(t *testing.T) {
+	delegate := mocks.NewBasicConsumerGroupsCommandClient()
+	client := sync.NewRecordingConsumerGroupInfoClient(delegate)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		KeepListed(ctx, client, time.Hour, time.Minute)
+		close(done)
+	}()
+	for client.Status().LastList.IsZero() {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if err := ListedWithinCheck(client, time.Minute)(context.Background()); err != nil {
+		t.Error("Expected the exporter to be ready without a scrape. Error:", err)
+	}
+
+	// Recently listed by a scrape.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	KeepListed(ctx, client, time.Hour, time.Minute)
+	if delegate.GroupInvocations != 1 {
+		t.Error("Expected no listing while groups were listed recently. Listings:", delegate.GroupInvocations)
+	}
+}
+
+func TestInFlightCheck(t *testing.T) {
+	var oldest time.Time
+	check := InFlightCheck(func() time.Time { return oldest }, time.Minute)
+
+	if err := check(context.Background()); err != nil {
+		t.Error("Expected no call in flight to pass. Error:", err)
+	}
+	oldest = time.Now().Add(-time.Second)
+	if err := check(context.Background()); err != nil {
+		t.Error("Expected a recent call to pass. Error:", err)
+	}
+	oldest = time.Now().Add(-time.Hour)
+	if err := check(context.Background()); err == nil {
+		t.Error("Expected a call running for an hour to fail.")
+	}
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+	log "github.com/sirupsen/logrus"
+)
+
+// StatusSource provides the status shown on the status page.
+type StatusSource interface {
+	Status() sync.RecordedStatus
+}
+
+// StatusPage is a HTML page showing the state of the exporter, for humans
+// diagnosing problems.
+type StatusPage struct {
+	bootstrapServers string
+	source           StatusSource
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// NewStatusPage returns a StatusPage showing bootstrapServers as the
+// bootstrap servers of the Kafka cluster, and the status recorded by source.
+func NewStatusPage(bootstrapServers string, source StatusSource) *StatusPage {
+	return &StatusPage{
+		bootstrapServers: bootstrapServers,
+		source:           source,
+		now:              time.Now,
+	}
+}
+
+type statusPageData struct {
+	BootstrapServers string
+	Now              time.Time
+	Status           sync.RecordedStatus
+}
+
+func (s *StatusPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Render into a buffer first, so a failing template results in a proper
+	// error.
+	var buf bytes.Buffer
+	err := statusTemplate.Execute(&buf, statusPageData{
+		BootstrapServers: s.bootstrapServers,
+		Now:              s.now(),
+		Status:           s.source.Status(),
+	})
+	if err != nil {
+		log.WithError(err).Error("Could not render status page")
+		http.Error(w, "could not render status page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// formatTime formats t relative to now, or "never" if it is zero.
+func formatTime(t, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339) + " (" + now.Sub(t).Truncate(time.Second).String() + " ago)"
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"formatTime": formatTime,
+	"pathEscape": url.PathEscape,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Kafka Consumer Group Exporter</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+td.number { text-align: right; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Kafka Consumer Group Exporter</h1>
+<p><a href="/metrics">Metrics</a> &middot; <a href="/api/v1/groups">JSON API</a></p>
+
+<h2>Cluster</h2>
+<p>Bootstrap servers: {{.BootstrapServers}}</p>
+
+<h2>Scrapes</h2>
+<p>Groups last listed: {{formatTime .Status.LastList .Now}}</p>
+{{- if .Status.ListError}}
+<p class="error">Listing groups failed: {{.Status.ListError}}</p>
+{{- end}}
+{{- if .Status.LastError}}
+<p class="error">Last error{{if .Status.LastErrorGroup}} describing group {{.Status.LastErrorGroup}}{{end}} at {{formatTime .Status.LastErrorTime .Now}}:</p>
+<pre class="error">{{.Status.LastError}}</pre>
+{{- end}}
+
+<h2>Consumer groups</h2>
+{{- if .Status.Groups}}
+<table>
+<tr><th>Group</th><th>Total lag</th><th>Partitions</th><th>Last described</th><th>Errors</th><th>Last error</th></tr>
+{{- range .Status.Groups}}
+<tr>
+<td><a href="/api/v1/groups/{{pathEscape .Group}}">{{.Group}}</a></td>
+<td class="number">{{.TotalLag}}</td>
+<td class="number">{{len .Partitions}}</td>
+<td>{{formatTime .LastSuccess $.Now}}</td>
+<td class="number">{{.Errors}}</td>
+<td class="error">{{.LastError}}</td>
+</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No consumer groups described yet.</p>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kawamuray/prometheus-kafka-consumer-group-exporter/sync"
+)
+
+type staticStatus sync.RecordedStatus
+
+func (s staticStatus) Status() sync.RecordedStatus {
+	return sync.RecordedStatus(s)
+}
+
+func TestStatusPage(t *testing.T) {
+	now := time.Unix(1060, 0)
+	page := NewStatusPage("kafka-1:9092,kafka-2:9092", staticStatus{
+		LastList: time.Unix(1000, 0),
+		Groups: []sync.GroupStatus{
+			{Group: "large", TotalLag: 150, LastSuccess: time.Unix(1000, 0)},
+			{Group: "<broken>", Errors: 3, LastError: "could not parse output"},
+			{Group: "orders?v=1#eu/west"},
+		},
+		LastError:      "could not parse output",
+		LastErrorGroup: "<broken>",
+		LastErrorTime:  time.Unix(1050, 0),
+	})
+	page.now = func() time.Time { return now }
+
+	w := httptest.NewRecorder()
+	page.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+
+	if w.Code != http.StatusOK {
+		t.Fatal("Unexpected status:", w.Code, body)
+	}
+	for _, expected := range []string{
+		"Bootstrap servers: kafka-1:9092,kafka-2:9092",
+		"Groups last listed: " + time.Unix(1000, 0).Format(time.RFC3339) + " (1m0s ago)",
+		"describing group &lt;broken&gt; at",
+		`<td class="number">150</td>`,
+		`<td class="number">3</td>`,
+		"<td>never</td>",
+		`<a href="/api/v1/groups/orders%3Fv=1%23eu%2Fwest">`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Error("Expected", expected, "in:", body)
+		}
+	}
+	if strings.Index(body, ">large<") > strings.Index(body, "&lt;broken&gt;</a>") {
+		t.Error("Expected groups in the given order.")
+	}
+
+	w = httptest.NewRecorder()
+	page.ServeHTTP(w, httptest.NewRequest("GET", "/other", nil))
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected other paths not to be found. Status:", w.Code)
+	}
+}